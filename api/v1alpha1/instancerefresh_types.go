@@ -0,0 +1,347 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Conditions of an InstanceRefresh. They are mirrored into the
+// alpha.aws.giantswarm.io/instance-refresh-status annotation of CRs which
+// requested the instance refresh by annotations.
+const (
+	InstanceRefreshInProgressCondition = "InstanceRefreshInProgress"
+	InstanceRefreshSucceededCondition  = "InstanceRefreshSucceeded"
+	InstanceRefreshFailedCondition     = "InstanceRefreshFailed"
+)
+
+const (
+	TargetKindAWSCluster           = "AWSCluster"
+	TargetKindAWSControlPlane      = "AWSControlPlane"
+	TargetKindAWSMachineDeployment = "AWSMachineDeployment"
+
+	// Cluster API kinds, the ones of the infrastructure.cluster.x-k8s.io
+	// group are provided by CAPA.
+	TargetKindMachinePool           = "MachinePool"
+	TargetKindAWSMachinePool        = "AWSMachinePool"
+	TargetKindAWSManagedMachinePool = "AWSManagedMachinePool"
+)
+
+// InstanceRefreshStrategy is the strategy instances get replaced with.
+// +kubebuilder:validation:Enum=Rolling
+type InstanceRefreshStrategy string
+
+const (
+	// RollingStrategy replaces instances using the instance refresh of the
+	// Auto Scaling group.
+	RollingStrategy InstanceRefreshStrategy = "Rolling"
+)
+
+// Roller is the mechanism replacing the instances of an ASG.
+// +kubebuilder:validation:Enum=InstanceRefresh;EKSNodegroup;Surge
+type Roller string
+
+const (
+	// InstanceRefreshRoller replaces instances with an instance refresh of
+	// the Auto Scaling group.
+	InstanceRefreshRoller Roller = "InstanceRefresh"
+	// EKSNodegroupRoller replaces instances by updating the EKS managed node
+	// group the Auto Scaling group belongs to.
+	EKSNodegroupRoller Roller = "EKSNodegroup"
+	// SurgeRoller launches new instances ahead of draining the nodes of
+	// outdated ones in the workload cluster and terminating them.
+	SurgeRoller Roller = "Surge"
+)
+
+// MaintenanceWindowPolicy decides what happens to instance refreshes in
+// progress when their maintenance window closes.
+// +kubebuilder:validation:Enum=Finish;Cancel
+type MaintenanceWindowPolicy string
+
+const (
+	// FinishMaintenanceWindowPolicy finishes the ASGs in flight and queues
+	// the remaining ones until the maintenance window opens again.
+	FinishMaintenanceWindowPolicy MaintenanceWindowPolicy = "Finish"
+	// CancelMaintenanceWindowPolicy cancels the instance refresh.
+	CancelMaintenanceWindowPolicy MaintenanceWindowPolicy = "Cancel"
+)
+
+// TargetReference references the CR whose Auto Scaling groups get refreshed.
+// It lives in the namespace of the InstanceRefresh.
+type TargetReference struct {
+	// +kubebuilder:validation:Enum=AWSCluster;AWSControlPlane;AWSMachineDeployment;MachinePool;AWSMachinePool;AWSManagedMachinePool
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+}
+
+// InstanceRefreshSpec defines the desired state of InstanceRefresh. Unset
+// fields fall back to the defaults of the operator.
+type InstanceRefreshSpec struct {
+	TargetRef TargetReference `json:"targetRef"`
+
+	// +optional
+	Strategy InstanceRefreshStrategy `json:"strategy,omitempty"`
+
+	// Roller replaces the instances of all ASGs. By default ASGs of EKS
+	// managed node groups are rolled by updating the node group and all
+	// others by an instance refresh.
+	// +optional
+	Roller Roller `json:"roller,omitempty"`
+
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	MinHealthyPercentage *int64 `json:"minHealthyPercentage,omitempty"`
+
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	InstanceWarmupSeconds *int64 `json:"instanceWarmupSeconds,omitempty"`
+
+	// MaxParallelASGs is the maximum number of node pool ASGs refreshed at
+	// the same time.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	MaxParallelASGs *int64 `json:"maxParallelASGs,omitempty"`
+
+	// Checkpoints are the ascending percentages of replaced instances at
+	// which the instance refresh pauses.
+	// +optional
+	Checkpoints []int64 `json:"checkpoints,omitempty"`
+
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=172800
+	// +optional
+	CheckpointDelaySeconds *int64 `json:"checkpointDelaySeconds,omitempty"`
+
+	// RequireApproval holds the instance refresh at every checkpoint until
+	// it got approved with ApprovedCheckpoint.
+	// +optional
+	RequireApproval bool `json:"requireApproval,omitempty"`
+
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	ApprovedCheckpoint int64 `json:"approvedCheckpoint,omitempty"`
+
+	// +optional
+	SkipMatching *bool `json:"skipMatching,omitempty"`
+
+	// LaunchTemplateVersion is a version number, $Latest or $Default.
+	// +optional
+	LaunchTemplateVersion string `json:"launchTemplateVersion,omitempty"`
+
+	// +optional
+	Rollback *bool `json:"rollback,omitempty"`
+
+	// Surge is the number of instances the Surge roller launches ahead of
+	// draining and terminating as many outdated ones. It defaults to 1.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Surge *int64 `json:"surge,omitempty"`
+
+	// MaxUnavailable is the maximum number of nodes of an EKS managed node
+	// group which are unavailable while it gets updated. It defaults to the
+	// share of nodes MinHealthyPercentage allows to be unavailable.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	MaxUnavailable *int64 `json:"maxUnavailable,omitempty"`
+
+	// Drain holds the instances an instance refresh terminates with a
+	// lifecycle hook until their nodes got drained in the workload cluster.
+	// +optional
+	Drain *bool `json:"drain,omitempty"`
+
+	// DrainTimeoutSeconds is the time after which instances get terminated
+	// even if their nodes are not drained yet. It defaults to 600.
+	// +kubebuilder:validation:Minimum=30
+	// +kubebuilder:validation:Maximum=7200
+	// +optional
+	DrainTimeoutSeconds *int64 `json:"drainTimeoutSeconds,omitempty"`
+
+	// CheckPDBs delays the instance refresh of an ASG while
+	// PodDisruptionBudgets of the workload cluster allow no disruptions of
+	// pods on its nodes.
+	// +optional
+	CheckPDBs *bool `json:"checkPDBs,omitempty"`
+
+	// CheckPDBsTimeoutSeconds is the time after which an ASG fails if
+	// PodDisruptionBudgets keep delaying its instance refresh. It defaults
+	// to 3600.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	CheckPDBsTimeoutSeconds *int64 `json:"checkPDBsTimeoutSeconds,omitempty"`
+
+	// HealthCheck holds back the next ASGs until the nodes of a refreshed
+	// ASG are Ready and the kube-system DaemonSets of the workload cluster
+	// are rolled out.
+	// +optional
+	HealthCheck *bool `json:"healthCheck,omitempty"`
+
+	// HealthCheckTimeoutSeconds is the time after which the instance
+	// refresh stops if the workload cluster is not healthy. It defaults to
+	// 900.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	HealthCheckTimeoutSeconds *int64 `json:"healthCheckTimeoutSeconds,omitempty"`
+
+	// HealthQuery is a PromQL expression which has to evaluate to true
+	// before an ASG gets refreshed and at every checkpoint, e.g. an error
+	// rate SLO. Otherwise the instance refresh gets cancelled. It defaults
+	// to the health query of the operator.
+	// +optional
+	HealthQuery string `json:"healthQuery,omitempty"`
+
+	// MaintenanceWindow restricts the times ASGs get started in, e.g.
+	// "Mon-Fri 22:00-05:00 Europe/Berlin". Outside of it the instance
+	// refresh is queued. It defaults to the maintenance window of the
+	// cluster or the operator.
+	// +optional
+	MaintenanceWindow string `json:"maintenanceWindow,omitempty"`
+
+	// MaintenanceWindowPolicy decides what happens to the instance refresh
+	// when the maintenance window closes while it is in progress. Finish
+	// finishes the ASGs in flight and queues the remaining ones, Cancel
+	// cancels the instance refresh.
+	// +optional
+	MaintenanceWindowPolicy MaintenanceWindowPolicy `json:"maintenanceWindowPolicy,omitempty"`
+
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	CooldownSeconds *int64 `json:"cooldownSeconds,omitempty"`
+
+	// Force refreshes ASGs regardless of the cooldown.
+	// +optional
+	Force bool `json:"force,omitempty"`
+
+	// Cancel cancels the instance refresh.
+	// +optional
+	Cancel bool `json:"cancel,omitempty"`
+}
+
+// ASGStatus is the progress of the instance refresh of a single ASG.
+type ASGStatus struct {
+	Name string `json:"name"`
+	// +optional
+	ControlPlane bool `json:"controlPlane,omitempty"`
+	// +optional
+	Stage int `json:"stage,omitempty"`
+	// +optional
+	InstanceRefreshID string `json:"instanceRefreshID,omitempty"`
+	// +optional
+	Status string `json:"status,omitempty"`
+	// +optional
+	Checkpoint int64 `json:"checkpoint,omitempty"`
+	// +optional
+	ResumedAt int64 `json:"resumedAt,omitempty"`
+	// +optional
+	SkipMatching bool `json:"skipMatching,omitempty"`
+	// +optional
+	Instances int64 `json:"instances,omitempty"`
+	// +optional
+	InstancesToUpdate int64 `json:"instancesToUpdate,omitempty"`
+	// +optional
+	PreviousLaunchTemplateVersion string `json:"previousLaunchTemplateVersion,omitempty"`
+	// +optional
+	RollingBack bool `json:"rollingBack,omitempty"`
+	// +optional
+	PercentageComplete int64 `json:"percentageComplete,omitempty"`
+	// +optional
+	StatusReason string `json:"statusReason,omitempty"`
+	// +optional
+	InstancesRemaining int64 `json:"instancesRemaining,omitempty"`
+	// +optional
+	ProgressReportedAt *metav1.Time `json:"progressReportedAt,omitempty"`
+	// +optional
+	EKSClusterName string `json:"eksClusterName,omitempty"`
+	// +optional
+	EKSNodegroupName string `json:"eksNodegroupName,omitempty"`
+	// +optional
+	Roller string `json:"roller,omitempty"`
+	// +optional
+	Surge int64 `json:"surge,omitempty"`
+	// +optional
+	InstancesToReplace []string `json:"instancesToReplace,omitempty"`
+	// +optional
+	DrainingInstances []string `json:"drainingInstances,omitempty"`
+	// +optional
+	DrainingSince map[string]metav1.Time `json:"drainingSince,omitempty"`
+	// +optional
+	DesiredCapacity int64 `json:"desiredCapacity,omitempty"`
+	// +optional
+	MaxSize int64 `json:"maxSize,omitempty"`
+	// +optional
+	ConfiguredLaunchTemplateVersion string `json:"configuredLaunchTemplateVersion,omitempty"`
+	// +optional
+	DrainTimeoutSeconds int64 `json:"drainTimeoutSeconds,omitempty"`
+	// +optional
+	VerifyingSince *metav1.Time `json:"verifyingSince,omitempty"`
+	// +optional
+	BlockedSince *metav1.Time `json:"blockedSince,omitempty"`
+}
+
+// InstanceRefreshStatus defines the observed state of InstanceRefresh
+type InstanceRefreshStatus struct {
+	// +optional
+	Phase string `json:"phase,omitempty"`
+	// +optional
+	ASGs []ASGStatus `json:"asgs,omitempty"`
+	// PercentageComplete is the average progress of all ASGs.
+	// +optional
+	PercentageComplete int64 `json:"percentageComplete,omitempty"`
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+	// +optional
+	EndTime *metav1.Time `json:"endTime,omitempty"`
+	// Message describes the outcome of the instance refresh.
+	// +optional
+	Message string `json:"message,omitempty"`
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Kind",type="string",JSONPath=".spec.targetRef.kind"
+//+kubebuilder:printcolumn:name="Target",type="string",JSONPath=".spec.targetRef.name"
+//+kubebuilder:printcolumn:name="Phase",type="string",JSONPath=".status.phase"
+//+kubebuilder:printcolumn:name="Progress",type="integer",JSONPath=".status.percentageComplete"
+//+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// InstanceRefresh is the Schema for the instancerefreshes API
+type InstanceRefresh struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   InstanceRefreshSpec   `json:"spec,omitempty"`
+	Status InstanceRefreshStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// InstanceRefreshList contains a list of InstanceRefresh
+type InstanceRefreshList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []InstanceRefresh `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&InstanceRefresh{}, &InstanceRefreshList{})
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/giantswarm/k8smetadata/pkg/annotation"
+	"github.com/giantswarm/microerror"
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/giantswarm/aws-rolling-node-operator/api/v1alpha1"
+	"github.com/giantswarm/aws-rolling-node-operator/pkg/key"
+)
+
+// AnnotationReconciler reconciles the instance refresh annotations of CRs of a
+// single kind. It is registered once per supported kind.
+type AnnotationReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+
+	// Kind is one of the target kinds of the InstanceRefresh.
+	Kind string
+
+	recorder record.EventRecorder
+}
+
+// +kubebuilder:rbac:groups=infrastructure.giantswarm.io,resources=awscluster;awscontrolplane;awsmachinedeployment,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=infrastructure.giantswarm.io,resources=awscluster/status;awscontrolplane/status;awsmachinedeployment/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=infrastructure.giantswarm.io,resources=awscluster/finalizers;awscontrolplane/finalizers;awsmachinedeployment/finalizers,verbs=update
+// +kubebuilder:rbac:groups=cluster.x-k8s.io,resources=machinepools,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=cluster.x-k8s.io,resources=clusters,verbs=get;list;watch
+// +kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=awsmachinepools;awsmanagedmachinepools,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=awsclusters;awsmanagedclusters;awsclusterroleidentities,verbs=get;list;watch
+// +kubebuilder:rbac:groups=controlplane.cluster.x-k8s.io,resources=awsmanagedcontrolplanes,verbs=get;list;watch
+
+// Reconcile is part of the main kubernetes reconciliation loop which aims to
+// move the current state of the cluster closer to the desired state.
+// For more details, check Reconcile and its Result here:
+// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.8.3/pkg/reconcile
+func (r *AnnotationReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	adapter := targetAdapters[r.Kind]
+	logger := r.Log.WithValues("namespace", req.Namespace, adapter.name, req.Name)
+
+	obj := adapter.newObject()
+	if err := r.Get(ctx, req.NamespacedName, obj); err != nil {
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, microerror.Mask(err)
+	}
+
+	compatibility := &annotationCompatibility{
+		client:   r.Client,
+		scheme:   r.Scheme,
+		recorder: r.recorder,
+		logger:   logger,
+	}
+	targetRef := v1alpha1.TargetReference{Kind: r.Kind, Name: obj.GetName()}
+
+	if !key.InstanceRefresh(obj) {
+		schedule, err := key.InstanceRefreshSchedule(obj)
+		if err != nil {
+			return defaultRequeue(), microerror.Mask(err)
+		}
+		if schedule != nil {
+			return compatibility.schedule(ctx, obj, targetRef, schedule)
+		}
+		logger.Info(
+			fmt.Sprintf("%s CR do not have required annotation '%s', ignoring CR",
+				r.Kind, annotation.AWSInstanceRefresh))
+		return defaultRequeue(), nil
+	}
+
+	return compatibility.reconcile(ctx, obj, targetRef, adapter.cluster(obj))
+}
+
+// SetupWithManager sets up the controller with the Manager. Kinds whose CRD
+// is optional are not watched if it is not installed.
+func (r *AnnotationReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	adapter, ok := targetAdapters[r.Kind]
+	if !ok {
+		return fmt.Errorf("unsupported kind %q", r.Kind)
+	}
+	if adapter.gvk != nil {
+		_, err := mgr.GetRESTMapper().RESTMapping(adapter.gvk.GroupKind(), adapter.gvk.Version)
+		if meta.IsNoMatchError(err) {
+			r.Log.Info(fmt.Sprintf("CRD of %s is not installed, not watching it", r.Kind))
+			return nil
+		} else if err != nil {
+			return microerror.Mask(err)
+		}
+	}
+	r.recorder = mgr.GetEventRecorderFor(fmt.Sprintf("aws-%s-node-rolling-controller", adapter.name))
+	return ctrl.NewControllerManagedBy(mgr).
+		For(adapter.newObject()).
+		Owns(&v1alpha1.InstanceRefresh{}).
+		Complete(r)
+}
+
+func defaultRequeue() reconcile.Result {
+	return ctrl.Result{
+		Requeue:      true,
+		RequeueAfter: time.Minute * 5,
+	}
+}
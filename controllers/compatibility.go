@@ -0,0 +1,362 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/giantswarm/k8smetadata/pkg/annotation"
+	"github.com/giantswarm/microerror"
+	"github.com/go-logr/logr"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	"github.com/giantswarm/aws-rolling-node-operator/api/v1alpha1"
+	"github.com/giantswarm/aws-rolling-node-operator/pkg/key"
+	"github.com/giantswarm/aws-rolling-node-operator/pkg/refresh"
+)
+
+// annotationCompatibility translates instance refresh annotations on target
+// CRs into InstanceRefresh objects, which do the actual work. Once the
+// InstanceRefresh finished, its outcome is reported as an event on the CR and
+// the annotations are removed. The InstanceRefresh is kept as a record. Its
+// status is mirrored into the instance refresh status annotation of the CR.
+type annotationCompatibility struct {
+	client   client.Client
+	scheme   *runtime.Scheme
+	recorder record.EventRecorder
+	logger   logr.Logger
+}
+
+func (a *annotationCompatibility) reconcile(ctx context.Context, obj client.Object, targetRef v1alpha1.TargetReference, clusterName string) (ctrl.Result, error) {
+	spec, err := instanceRefreshSpec(obj, targetRef)
+	if err != nil {
+		return defaultRequeue(), microerror.Mask(err)
+	}
+
+	name := obj.GetAnnotations()[key.InstanceRefreshNameAnnotation]
+	if name == "" {
+		// The name is recorded before the InstanceRefresh gets created, so a
+		// failed update never leaves an orphaned InstanceRefresh behind.
+		annotations := obj.GetAnnotations()
+		annotations[key.InstanceRefreshNameAnnotation] = fmt.Sprintf("%s-%s", obj.GetName(), utilrand.String(5))
+		obj.SetAnnotations(annotations)
+		return ctrl.Result{}, a.update(ctx, obj)
+	}
+
+	instanceRefresh := &v1alpha1.InstanceRefresh{}
+	err = a.client.Get(ctx, types.NamespacedName{Name: name, Namespace: obj.GetNamespace()}, instanceRefresh)
+	if errors.IsNotFound(err) {
+		return ctrl.Result{}, a.create(ctx, obj, name, clusterName, spec)
+	} else if err != nil {
+		return defaultRequeue(), microerror.Mask(err)
+	}
+
+	statusChanged, err := setStatusAnnotation(obj, instanceRefresh)
+	if err != nil {
+		return defaultRequeue(), microerror.Mask(err)
+	}
+
+	nodes := targetNodes(targetRef.Kind)
+	switch refresh.Phase(instanceRefresh.Status.Phase) {
+	case refresh.PhaseSuccessful:
+		a.recorder.Event(obj, v1.EventTypeNormal, "InstanceRefreshSuccessful",
+			fmt.Sprintf("Replaced all %s nodes. %s", nodes, instanceRefresh.Status.Message))
+	case refresh.PhaseFailed, refresh.PhaseCancelled:
+		a.recorder.Event(obj, v1.EventTypeWarning, "InstanceRefreshCancelled", instanceRefresh.Status.Message)
+	default:
+		// Only cancelling and approving checkpoints affect an instance
+		// refresh which is already in progress.
+		if instanceRefresh.Spec.Cancel != spec.Cancel || instanceRefresh.Spec.ApprovedCheckpoint != spec.ApprovedCheckpoint {
+			instanceRefresh.Spec.Cancel = spec.Cancel
+			instanceRefresh.Spec.ApprovedCheckpoint = spec.ApprovedCheckpoint
+			err = a.update(ctx, instanceRefresh)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		if !statusChanged {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, a.update(ctx, obj)
+	}
+
+	removeInstanceRefreshAnnotations(obj)
+	return ctrl.Result{}, a.update(ctx, obj)
+}
+
+func (a *annotationCompatibility) create(ctx context.Context, obj client.Object, name, clusterName string, spec v1alpha1.InstanceRefreshSpec) error {
+	instanceRefresh := &v1alpha1.InstanceRefresh{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: obj.GetNamespace(),
+			Labels: map[string]string{
+				key.ClusterLabel: clusterName,
+			},
+			Annotations: map[string]string{
+				key.InstanceRefreshTriggerAnnotation: key.Trigger(obj),
+			},
+		},
+		Spec: spec,
+	}
+	err := controllerutil.SetControllerReference(obj, instanceRefresh, a.scheme)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	err = a.client.Create(ctx, instanceRefresh)
+	if errors.IsAlreadyExists(err) {
+		return nil
+	} else if err != nil {
+		a.logger.Error(err, "failed to create InstanceRefresh")
+		return microerror.Mask(err)
+	}
+
+	a.recorder.Event(obj, v1.EventTypeNormal, "InstanceRefreshIsStarting",
+		fmt.Sprintf("Starting to replace all %s nodes.", targetNodes(spec.TargetRef.Kind)))
+	return nil
+}
+
+func (a *annotationCompatibility) update(ctx context.Context, obj client.Object) error {
+	err := a.client.Update(ctx, obj)
+	if errors.IsConflict(err) {
+		a.logger.Info(fmt.Sprintf("Failed to update %s, conflict trying to update object", obj.GetName()))
+	} else if err != nil {
+		a.logger.Error(err, "failed to update object")
+		return microerror.Mask(err)
+	}
+	return nil
+}
+
+// refreshStatus is the status of an instance refresh as recorded in the
+// instance refresh status annotation, so dashboards and kubectl can show the
+// state of a roll without parsing events.
+type refreshStatus struct {
+	InstanceRefresh    string             `json:"instanceRefresh"`
+	Phase              string             `json:"phase"`
+	PercentageComplete int64              `json:"percentageComplete"`
+	Conditions         []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// setStatusAnnotation mirrors the status of the given InstanceRefresh into the
+// annotations of the CR and reports whether they changed. The annotation is
+// kept once the instance refresh finished.
+func setStatusAnnotation(obj client.Object, instanceRefresh *v1alpha1.InstanceRefresh) (bool, error) {
+	phase := instanceRefresh.Status.Phase
+	if phase == "" {
+		phase = string(refresh.PhasePending)
+	}
+	data, err := json.Marshal(refreshStatus{
+		InstanceRefresh:    instanceRefresh.Name,
+		Phase:              phase,
+		PercentageComplete: instanceRefresh.Status.PercentageComplete,
+		Conditions:         instanceRefresh.Status.Conditions,
+	})
+	if err != nil {
+		return false, microerror.Mask(err)
+	}
+
+	annotations := obj.GetAnnotations()
+	if annotations[key.InstanceRefreshStatusAnnotation] == string(data) {
+		return false, nil
+	}
+	annotations[key.InstanceRefreshStatusAnnotation] = string(data)
+	obj.SetAnnotations(annotations)
+	return true, nil
+}
+
+// instanceRefreshSpec translates the instance refresh annotations of the
+// given CR into the spec of an InstanceRefresh. Settings without annotation
+// are left unset so the defaults of the operator apply.
+func instanceRefreshSpec(obj key.AnnotationsGetter, targetRef v1alpha1.TargetReference) (v1alpha1.InstanceRefreshSpec, error) {
+	var err error
+	spec := v1alpha1.InstanceRefreshSpec{
+		TargetRef: targetRef,
+		Strategy:  v1alpha1.RollingStrategy,
+		Cancel:    key.CancelInstanceRefresh(obj),
+	}
+
+	spec.MinHealthyPercentage, err = optionalInt64(obj, annotation.AWSInstanceRefreshMinHealthyPercentage, func() (int64, error) {
+		return key.MinHealthyPercentage(obj)
+	})
+	if err != nil {
+		return spec, err
+	}
+	spec.InstanceWarmupSeconds, err = optionalInt64(obj, annotation.AWSInstanceWarmupSeconds, func() (int64, error) {
+		return key.InstanceWarmupSeconds(obj)
+	})
+	if err != nil {
+		return spec, err
+	}
+	spec.MaxParallelASGs, err = optionalInt64(obj, key.MaxParallelASGsAnnotation, func() (int64, error) {
+		return key.MaxParallelASGs(obj, 1)
+	})
+	if err != nil {
+		return spec, err
+	}
+	spec.Checkpoints, err = key.InstanceRefreshCheckpoints(obj)
+	if err != nil {
+		return spec, microerror.Mask(err)
+	}
+	spec.CheckpointDelaySeconds, err = optionalInt64(obj, key.InstanceRefreshCheckpointDelaySecondsAnnotation, func() (int64, error) {
+		return key.InstanceRefreshCheckpointDelaySeconds(obj)
+	})
+	if err != nil {
+		return spec, err
+	}
+	spec.RequireApproval, err = key.InstanceRefreshRequireApproval(obj)
+	if err != nil {
+		return spec, microerror.Mask(err)
+	}
+	spec.ApprovedCheckpoint, err = key.InstanceRefreshApprovedCheckpoint(obj)
+	if err != nil {
+		return spec, microerror.Mask(err)
+	}
+	spec.SkipMatching, err = optionalBool(obj, key.InstanceRefreshSkipMatchingAnnotation, func() (bool, error) {
+		return key.InstanceRefreshSkipMatching(obj, false)
+	})
+	if err != nil {
+		return spec, err
+	}
+	spec.LaunchTemplateVersion, err = key.InstanceRefreshLaunchTemplateVersion(obj)
+	if err != nil {
+		return spec, microerror.Mask(err)
+	}
+	spec.Rollback, err = optionalBool(obj, key.InstanceRefreshRollbackAnnotation, func() (bool, error) {
+		return key.InstanceRefreshRollback(obj, false)
+	})
+	if err != nil {
+		return spec, err
+	}
+	spec.CooldownSeconds, err = optionalInt64(obj, key.InstanceRefreshCooldownSecondsAnnotation, func() (int64, error) {
+		return key.InstanceRefreshCooldownSeconds(obj, 0)
+	})
+	if err != nil {
+		return spec, err
+	}
+	spec.Force, err = key.InstanceRefreshForce(obj)
+	if err != nil {
+		return spec, microerror.Mask(err)
+	}
+	spec.Roller, err = key.InstanceRefreshRoller(obj)
+	if err != nil {
+		return spec, microerror.Mask(err)
+	}
+	spec.Surge, err = optionalInt64(obj, key.InstanceRefreshSurgeAnnotation, func() (int64, error) {
+		return key.InstanceRefreshSurge(obj)
+	})
+	if err != nil {
+		return spec, err
+	}
+	spec.MaxUnavailable, err = optionalInt64(obj, key.InstanceRefreshMaxUnavailableAnnotation, func() (int64, error) {
+		return key.InstanceRefreshMaxUnavailable(obj)
+	})
+	if err != nil {
+		return spec, err
+	}
+	spec.Drain, err = optionalBool(obj, key.InstanceRefreshDrainAnnotation, func() (bool, error) {
+		return key.InstanceRefreshDrain(obj, false)
+	})
+	if err != nil {
+		return spec, err
+	}
+	spec.DrainTimeoutSeconds, err = optionalInt64(obj, key.InstanceRefreshDrainTimeoutSecondsAnnotation, func() (int64, error) {
+		return key.InstanceRefreshDrainTimeoutSeconds(obj)
+	})
+	if err != nil {
+		return spec, err
+	}
+	spec.CheckPDBs, err = optionalBool(obj, key.InstanceRefreshCheckPDBsAnnotation, func() (bool, error) {
+		return key.InstanceRefreshCheckPDBs(obj, false)
+	})
+	if err != nil {
+		return spec, err
+	}
+	spec.CheckPDBsTimeoutSeconds, err = optionalInt64(obj, key.InstanceRefreshCheckPDBsTimeoutSecondsAnnotation, func() (int64, error) {
+		return key.InstanceRefreshCheckPDBsTimeoutSeconds(obj)
+	})
+	if err != nil {
+		return spec, err
+	}
+	spec.HealthCheck, err = optionalBool(obj, key.InstanceRefreshHealthCheckAnnotation, func() (bool, error) {
+		return key.InstanceRefreshHealthCheck(obj, false)
+	})
+	if err != nil {
+		return spec, err
+	}
+	spec.HealthCheckTimeoutSeconds, err = optionalInt64(obj, key.InstanceRefreshHealthCheckTimeoutSecondsAnnotation, func() (int64, error) {
+		return key.InstanceRefreshHealthCheckTimeoutSeconds(obj)
+	})
+	if err != nil {
+		return spec, err
+	}
+	spec.HealthQuery = key.InstanceRefreshHealthQuery(obj)
+
+	return spec, nil
+}
+
+func optionalInt64(obj key.AnnotationsGetter, name string, parse func() (int64, error)) (*int64, error) {
+	if _, ok := obj.GetAnnotations()[name]; !ok {
+		return nil, nil
+	}
+	v, err := parse()
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	return &v, nil
+}
+
+func optionalBool(obj key.AnnotationsGetter, name string, parse func() (bool, error)) (*bool, error) {
+	if _, ok := obj.GetAnnotations()[name]; !ok {
+		return nil, nil
+	}
+	v, err := parse()
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	return &v, nil
+}
+
+func removeInstanceRefreshAnnotations(obj client.Object) {
+	annotations := obj.GetAnnotations()
+	delete(annotations, annotation.AWSInstanceRefresh)
+	delete(annotations, annotation.AWSCancelInstanceRefresh)
+	delete(annotations, key.InstanceRefreshApproveCheckpointAnnotation)
+	delete(annotations, key.InstanceRefreshForceAnnotation)
+	delete(annotations, key.InstanceRefreshNameAnnotation)
+	delete(annotations, key.InstanceRefreshTriggerAnnotation)
+	// The settings of a schedule apply to all of its instance refreshes.
+	if _, ok := annotations[key.InstanceRefreshScheduleAnnotation]; ok {
+		obj.SetAnnotations(annotations)
+		return
+	}
+	delete(annotations, annotation.AWSInstanceRefreshMinHealthyPercentage)
+	delete(annotations, annotation.AWSInstanceWarmupSeconds)
+	delete(annotations, key.MaxParallelASGsAnnotation)
+	delete(annotations, key.InstanceRefreshCheckpointsAnnotation)
+	delete(annotations, key.InstanceRefreshCheckpointDelaySecondsAnnotation)
+	delete(annotations, key.InstanceRefreshRequireApprovalAnnotation)
+	delete(annotations, key.InstanceRefreshSkipMatchingAnnotation)
+	delete(annotations, key.InstanceRefreshLaunchTemplateVersionAnnotation)
+	delete(annotations, key.InstanceRefreshRollbackAnnotation)
+	delete(annotations, key.InstanceRefreshCooldownSecondsAnnotation)
+	delete(annotations, key.InstanceRefreshRollerAnnotation)
+	delete(annotations, key.InstanceRefreshSurgeAnnotation)
+	delete(annotations, key.InstanceRefreshMaxUnavailableAnnotation)
+	delete(annotations, key.InstanceRefreshDrainAnnotation)
+	delete(annotations, key.InstanceRefreshDrainTimeoutSecondsAnnotation)
+	delete(annotations, key.InstanceRefreshCheckPDBsAnnotation)
+	delete(annotations, key.InstanceRefreshCheckPDBsTimeoutSecondsAnnotation)
+	delete(annotations, key.InstanceRefreshHealthCheckAnnotation)
+	delete(annotations, key.InstanceRefreshHealthCheckTimeoutSecondsAnnotation)
+	delete(annotations, key.InstanceRefreshHealthQueryAnnotation)
+	obj.SetAnnotations(annotations)
+}
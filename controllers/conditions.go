@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/giantswarm/aws-rolling-node-operator/api/v1alpha1"
+	"github.com/giantswarm/aws-rolling-node-operator/pkg/refresh"
+)
+
+// setConditions derives the conditions of an InstanceRefresh from its phase.
+// Exactly one of them is true at any time, except before the instance
+// refresh started.
+func setConditions(status *v1alpha1.InstanceRefreshStatus, generation int64) {
+	phase := refresh.Phase(status.Phase)
+	if phase == "" {
+		phase = refresh.PhasePending
+	}
+
+	inProgress := metav1.Condition{
+		Type:               v1alpha1.InstanceRefreshInProgressCondition,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: generation,
+		Reason:             string(phase),
+	}
+	succeeded := metav1.Condition{
+		Type:               v1alpha1.InstanceRefreshSucceededCondition,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: generation,
+		Reason:             string(phase),
+	}
+	failed := metav1.Condition{
+		Type:               v1alpha1.InstanceRefreshFailedCondition,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: generation,
+		Reason:             string(phase),
+	}
+
+	switch phase {
+	case refresh.PhaseInProgress:
+		inProgress.Status = metav1.ConditionTrue
+		inProgress.Message = fmt.Sprintf("Instance refresh is %d%% complete.", status.PercentageComplete)
+	case refresh.PhaseAwaitingApproval:
+		inProgress.Status = metav1.ConditionTrue
+		inProgress.Message = fmt.Sprintf("Instance refresh is %d%% complete and awaits approval.", status.PercentageComplete)
+	case refresh.PhaseCancelling:
+		inProgress.Status = metav1.ConditionTrue
+		inProgress.Message = "Instance refresh got cancelled and waits for rolls which can not be cancelled to end."
+	case refresh.PhaseSuccessful:
+		succeeded.Status = metav1.ConditionTrue
+		succeeded.Message = status.Message
+	case refresh.PhaseFailed, refresh.PhaseCancelled:
+		failed.Status = metav1.ConditionTrue
+		failed.Message = status.Message
+	}
+
+	meta.SetStatusCondition(&status.Conditions, inProgress)
+	meta.SetStatusCondition(&status.Conditions, succeeded)
+	meta.SetStatusCondition(&status.Conditions, failed)
+}
@@ -0,0 +1,45 @@
+package controllers
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+
+	"github.com/giantswarm/aws-rolling-node-operator/api/v1alpha1"
+	"github.com/giantswarm/aws-rolling-node-operator/pkg/refresh"
+)
+
+func TestSetConditions(t *testing.T) {
+	testCases := []struct {
+		phase    refresh.Phase
+		expected string
+	}{
+		{phase: refresh.PhaseInProgress, expected: v1alpha1.InstanceRefreshInProgressCondition},
+		{phase: refresh.PhaseAwaitingApproval, expected: v1alpha1.InstanceRefreshInProgressCondition},
+		{phase: refresh.PhaseSuccessful, expected: v1alpha1.InstanceRefreshSucceededCondition},
+		{phase: refresh.PhaseFailed, expected: v1alpha1.InstanceRefreshFailedCondition},
+		{phase: refresh.PhaseCancelled, expected: v1alpha1.InstanceRefreshFailedCondition},
+	}
+
+	for _, tc := range testCases {
+		t.Run(string(tc.phase), func(t *testing.T) {
+			status := &v1alpha1.InstanceRefreshStatus{Phase: string(refresh.PhasePending)}
+			setConditions(status, 1)
+			status.Phase = string(tc.phase)
+			status.PercentageComplete = 40
+			setConditions(status, 1)
+
+			if len(status.Conditions) != 3 {
+				t.Fatalf("expected 3 conditions, got %d", len(status.Conditions))
+			}
+			for _, c := range status.Conditions {
+				if c.Reason != string(tc.phase) {
+					t.Fatalf("expected reason %s, got %s", tc.phase, c.Reason)
+				}
+				if meta.IsStatusConditionTrue(status.Conditions, c.Type) != (c.Type == tc.expected) {
+					t.Fatalf("expected only %s to be true, got %+v", tc.expected, status.Conditions)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,16 @@
+package controllers
+
+import (
+	"errors"
+
+	"github.com/giantswarm/microerror"
+)
+
+var invalidTargetError = &microerror.Error{
+	Kind: "invalidTargetError",
+}
+
+// IsInvalidTarget asserts invalidTargetError.
+func IsInvalidTarget(err error) bool {
+	return errors.Is(err, invalidTargetError)
+}
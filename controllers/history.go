@@ -0,0 +1,181 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/giantswarm/microerror"
+	"github.com/go-logr/logr"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	"github.com/giantswarm/aws-rolling-node-operator/api/v1alpha1"
+	"github.com/giantswarm/aws-rolling-node-operator/pkg/key"
+)
+
+const (
+	// historyLimit is the number of instance refreshes kept in the history
+	// of a cluster. Older ones are dropped.
+	historyLimit = 20
+	historyKey   = "history"
+)
+
+// historyRecord describes a finished instance refresh in the history of its
+// cluster, for post-incident reviews and change audits.
+type historyRecord struct {
+	InstanceRefresh string    `json:"instanceRefresh"`
+	UID             types.UID `json:"uid"`
+	// TriggeredBy is the CR the instance refresh got requested on by
+	// annotations, or the InstanceRefresh itself.
+	TriggeredBy string `json:"triggeredBy"`
+	// Trigger is what requested the instance refresh, i.e. its schedule, the
+	// instance refresh annotation or the InstanceRefresh itself.
+	Trigger   string                       `json:"trigger"`
+	Spec      v1alpha1.InstanceRefreshSpec `json:"spec"`
+	Phase     string                       `json:"phase"`
+	Message   string                       `json:"message,omitempty"`
+	StartTime *metav1.Time                 `json:"startTime,omitempty"`
+	EndTime   *metav1.Time                 `json:"endTime,omitempty"`
+	Duration  string                       `json:"duration,omitempty"`
+	ASGs      []historyASG                 `json:"asgs,omitempty"`
+}
+
+type historyASG struct {
+	Name              string `json:"name"`
+	InstanceRefreshID string `json:"instanceRefreshID,omitempty"`
+	Status            string `json:"status,omitempty"`
+	Instances         int64  `json:"instances,omitempty"`
+}
+
+// historyConfigMapName returns the name of the ConfigMap holding the instance
+// refresh history of the given cluster.
+func historyConfigMapName(clusterName string) string {
+	return fmt.Sprintf("%s-instance-refresh-history", clusterName)
+}
+
+// newHistoryRecord describes the given finished InstanceRefresh.
+func newHistoryRecord(instanceRefresh *v1alpha1.InstanceRefresh) historyRecord {
+	record := historyRecord{
+		InstanceRefresh: instanceRefresh.Name,
+		UID:             instanceRefresh.UID,
+		TriggeredBy:     fmt.Sprintf("InstanceRefresh/%s", instanceRefresh.Name),
+		Trigger:         key.TriggerInstanceRefresh,
+		Spec:            instanceRefresh.Spec,
+		Phase:           instanceRefresh.Status.Phase,
+		Message:         instanceRefresh.Status.Message,
+		StartTime:       instanceRefresh.Status.StartTime,
+		EndTime:         instanceRefresh.Status.EndTime,
+	}
+	if owner := metav1.GetControllerOf(instanceRefresh); owner != nil {
+		record.TriggeredBy = fmt.Sprintf("%s/%s", owner.Kind, owner.Name)
+		record.Trigger = key.Trigger(instanceRefresh)
+	}
+	if record.StartTime != nil && record.EndTime != nil {
+		record.Duration = record.EndTime.Sub(record.StartTime.Time).Round(time.Second).String()
+	}
+	for _, a := range instanceRefresh.Status.ASGs {
+		record.ASGs = append(record.ASGs, historyASG{
+			Name:              a.Name,
+			InstanceRefreshID: a.InstanceRefreshID,
+			Status:            a.Status,
+			Instances:         a.Instances,
+		})
+	}
+	return record
+}
+
+// appendHistory appends the given record to the history and drops the oldest
+// records beyond the limit. A record of the same InstanceRefresh replaces
+// the previous one, so recording it again after a conflict is harmless.
+func appendHistory(history []historyRecord, record historyRecord, limit int) []historyRecord {
+	var result []historyRecord
+	for _, h := range history {
+		if h.UID != record.UID {
+			result = append(result, h)
+		}
+	}
+	result = append(result, record)
+	if len(result) > limit {
+		result = result[len(result)-limit:]
+	}
+	return result
+}
+
+// recordHistory appends the given finished InstanceRefresh to the history
+// ConfigMap of its cluster. The ConfigMap is owned by the AWSCluster, so it
+// gets deleted along with the cluster.
+func (r *InstanceRefreshReconciler) recordHistory(ctx context.Context, logger logr.Logger, instanceRefresh *v1alpha1.InstanceRefresh) error {
+	var cluster client.Object
+	clusterName := key.Cluster(instanceRefresh)
+	target, err := r.target(ctx, instanceRefresh)
+	if err == nil {
+		cluster = target.cluster
+		clusterName = target.clusterName
+	} else if !invalidTarget(err) {
+		return microerror.Mask(err)
+	}
+	if clusterName == "" {
+		logger.Info("Cluster of InstanceRefresh is unknown, not recording it in the history")
+		return nil
+	}
+
+	configMap := &v1.ConfigMap{}
+	err = r.Get(ctx, types.NamespacedName{Name: historyConfigMapName(clusterName), Namespace: instanceRefresh.Namespace}, configMap)
+	create := errors.IsNotFound(err)
+	if create {
+		configMap = &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      historyConfigMapName(clusterName),
+				Namespace: instanceRefresh.Namespace,
+				Labels: map[string]string{
+					key.ClusterLabel: clusterName,
+				},
+			},
+		}
+		if cluster != nil {
+			err = controllerutil.SetOwnerReference(cluster, configMap, r.Scheme)
+			if err != nil {
+				return microerror.Mask(err)
+			}
+		}
+	} else if err != nil {
+		return microerror.Mask(err)
+	}
+
+	var history []historyRecord
+	if data := configMap.Data[historyKey]; data != "" {
+		err = json.Unmarshal([]byte(data), &history)
+		if err != nil {
+			// A broken history must not block instance refreshes, it is
+			// started over instead.
+			logger.Error(err, "failed to parse instance refresh history, starting over")
+			history = nil
+		}
+	}
+	history = appendHistory(history, newHistoryRecord(instanceRefresh), historyLimit)
+
+	data, err := json.Marshal(history)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	if configMap.Data == nil {
+		configMap.Data = map[string]string{}
+	}
+	configMap.Data[historyKey] = string(data)
+
+	if create {
+		err = r.Create(ctx, configMap)
+	} else {
+		err = r.Update(ctx, configMap)
+	}
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package controllers
+
+import (
+	"fmt"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/giantswarm/aws-rolling-node-operator/api/v1alpha1"
+	"github.com/giantswarm/aws-rolling-node-operator/pkg/key"
+)
+
+func TestAppendHistory(t *testing.T) {
+	var history []historyRecord
+	for i := 0; i < 4; i++ {
+		history = appendHistory(history, historyRecord{UID: types.UID(fmt.Sprint(i))}, 3)
+	}
+	history = appendHistory(history, historyRecord{UID: "3", Phase: "Successful"}, 3)
+
+	if len(history) != 3 {
+		t.Fatalf("expected 3 records, got %d", len(history))
+	}
+	if history[0].UID != "1" || history[2].UID != "3" || history[2].Phase != "Successful" {
+		t.Fatalf("expected the oldest record to be dropped and the last one replaced, got %+v", history)
+	}
+}
+
+func TestNewHistoryRecord(t *testing.T) {
+	controller := true
+	start := metav1.Unix(0, 0)
+	end := metav1.Unix(90, 0)
+	instanceRefresh := &v1alpha1.InstanceRefresh{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "nodepool0-x7k2p",
+			OwnerReferences: []metav1.OwnerReference{
+				{Kind: v1alpha1.TargetKindAWSMachineDeployment, Name: "nodepool0", Controller: &controller},
+			},
+		},
+		Status: v1alpha1.InstanceRefreshStatus{
+			Phase:     "Successful",
+			StartTime: &start,
+			EndTime:   &end,
+			ASGs:      []v1alpha1.ASGStatus{{Name: "asg-1", InstanceRefreshID: "id-1", Status: "Successful", Instances: 3}},
+		},
+	}
+
+	record := newHistoryRecord(instanceRefresh)
+	if record.TriggeredBy != "AWSMachineDeployment/nodepool0" {
+		t.Fatalf("expected the instance refresh to be triggered by the annotated CR, got %s", record.TriggeredBy)
+	}
+	if record.Trigger != key.TriggerAnnotation {
+		t.Fatalf("expected the instance refresh to be triggered by annotation, got %s", record.Trigger)
+	}
+	if record.Duration != "1m30s" {
+		t.Fatalf("expected duration 1m30s, got %s", record.Duration)
+	}
+	if len(record.ASGs) != 1 || record.ASGs[0].InstanceRefreshID != "id-1" {
+		t.Fatalf("expected ASGs to be recorded, got %+v", record.ASGs)
+	}
+
+	instanceRefresh.Annotations = map[string]string{key.InstanceRefreshTriggerAnnotation: key.TriggerSchedule}
+	record = newHistoryRecord(instanceRefresh)
+	if record.Trigger != key.TriggerSchedule {
+		t.Fatalf("expected the instance refresh to be triggered by the schedule, got %s", record.Trigger)
+	}
+
+	instanceRefresh.OwnerReferences = nil
+	record = newHistoryRecord(instanceRefresh)
+	if record.TriggeredBy != "InstanceRefresh/nodepool0-x7k2p" || record.Trigger != key.TriggerInstanceRefresh {
+		t.Fatalf("expected the instance refresh to be triggered by itself, got %s by %s", record.Trigger, record.TriggeredBy)
+	}
+}
@@ -0,0 +1,413 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/giantswarm/microerror"
+	"github.com/go-logr/logr"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	"github.com/giantswarm/aws-rolling-node-operator/api/v1alpha1"
+	"github.com/giantswarm/aws-rolling-node-operator/pkg/aws/scope"
+	"github.com/giantswarm/aws-rolling-node-operator/pkg/capi"
+	"github.com/giantswarm/aws-rolling-node-operator/pkg/key"
+	"github.com/giantswarm/aws-rolling-node-operator/pkg/promql"
+	"github.com/giantswarm/aws-rolling-node-operator/pkg/refresh"
+	"github.com/giantswarm/aws-rolling-node-operator/pkg/workload"
+)
+
+// InstanceRefreshReconciler reconciles an InstanceRefresh object
+type InstanceRefreshReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+
+	Installation    string
+	MaxParallelASGs int64
+	SkipMatching    bool
+	Rollback        bool
+	Drain           bool
+	CheckPDBs       bool
+	HealthCheck     bool
+	// PrometheusAddress is the Prometheus HTTP API health queries are
+	// evaluated against, HealthQuery the default health query.
+	PrometheusAddress string
+	HealthQuery       string
+	// MaintenanceWindow and MaintenanceWindowPolicy apply to clusters
+	// without a maintenance window of their own.
+	MaintenanceWindow       string
+	MaintenanceWindowPolicy string
+	RefreshCooldown         time.Duration
+	recorder                record.EventRecorder
+}
+
+// +kubebuilder:rbac:groups=aws.giantswarm.io,resources=instancerefreshes,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=aws.giantswarm.io,resources=instancerefreshes/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+
+// Reconcile advances the instance refresh by a single step and records its
+// progress in the status of the InstanceRefresh. Finished instance refreshes
+// are left alone.
+func (r *InstanceRefreshReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := r.Log.WithValues("namespace", req.Namespace, "instancerefresh", req.Name)
+
+	instanceRefresh := &v1alpha1.InstanceRefresh{}
+	if err := r.Get(ctx, req.NamespacedName, instanceRefresh); err != nil {
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, microerror.Mask(err)
+	}
+	if finished(instanceRefresh.Status.Phase) {
+		return ctrl.Result{}, nil
+	}
+	status := instanceRefresh.Status.DeepCopy()
+
+	err := validate(instanceRefresh.Spec, r.PrometheusAddress)
+	if err != nil {
+		return r.fail(ctx, logger, instanceRefresh, status, err.Error())
+	}
+
+	target, err := r.target(ctx, instanceRefresh)
+	if invalidTarget(err) {
+		return r.fail(ctx, logger, instanceRefresh, status, err.Error())
+	} else if err != nil {
+		return defaultRequeue(), microerror.Mask(err)
+	}
+
+	clusterScope, err := scope.NewClusterScope(scope.ClusterScopeParams{
+		AccountID:        target.accountID,
+		ARN:              target.arn,
+		ClusterName:      target.clusterName,
+		ClusterNamespace: instanceRefresh.Namespace,
+		Installation:     r.Installation,
+		Region:           target.region,
+
+		Logger: logger,
+	})
+	if err != nil {
+		return ctrl.Result{}, microerror.Mask(err)
+	}
+
+	state := stateFromStatus(instanceRefresh.Status)
+	phase := state.Phase
+
+	instanceRefreshService := refresh.New(clusterScope, r.Client, r.recorder, instanceRefresh)
+	instanceRefreshService.ClusterTags = target.clusterTags
+	instanceRefreshService.WorkloadCluster = func(ctx context.Context) (kubernetes.Interface, error) {
+		return workload.NewClient(ctx, r.Client, instanceRefresh.Namespace, target.clusterName)
+	}
+	if r.PrometheusAddress != "" {
+		instanceRefreshService.Prometheus, err = promql.New(r.PrometheusAddress)
+		if err != nil {
+			return ctrl.Result{}, microerror.Mask(err)
+		}
+	}
+	done, err := instanceRefreshService.Refresh(ctx, state, r.preferences(instanceRefresh.Spec, target.cluster), target.filter)
+	applyState(&instanceRefresh.Status, state)
+	if _, ok := err.(awserr.Error); ok || refresh.IsTransient(err) {
+		// Refresh may have changed AWS before it failed, e.g. started the
+		// instance refresh of an ASG or cancelled one at a checkpoint. The
+		// state describing that is persisted before retrying.
+		_, updateErr := r.updateStatus(ctx, logger, instanceRefresh, status)
+		if updateErr != nil {
+			return defaultRequeue(), updateErr
+		}
+		return defaultRequeue(), microerror.Mask(err)
+	}
+
+	if instanceRefresh.Status.StartTime == nil {
+		now := metav1.Now()
+		instanceRefresh.Status.StartTime = &now
+	}
+	nodes := targetNodes(instanceRefresh.Spec.TargetRef.Kind)
+	if err != nil && !done {
+		// Refresh gave up with rolls in flight. They are cancelled, as the
+		// failed instance refresh is not reconciled anymore.
+		abortErr := instanceRefreshService.Abort(ctx, state)
+		applyState(&instanceRefresh.Status, state)
+		if abortErr != nil {
+			_, updateErr := r.updateStatus(ctx, logger, instanceRefresh, status)
+			if updateErr != nil {
+				return defaultRequeue(), updateErr
+			}
+			return defaultRequeue(), microerror.Mask(abortErr)
+		}
+	}
+	if err != nil {
+		if !finished(instanceRefresh.Status.Phase) {
+			instanceRefresh.Status.Phase = string(refresh.PhaseFailed)
+		}
+		return r.fail(ctx, logger, instanceRefresh, status, err.Error())
+	} else if done {
+		now := metav1.Now()
+		instanceRefresh.Status.EndTime = &now
+		instanceRefresh.Status.Message = state.Summary()
+		r.recorder.Event(instanceRefresh, v1.EventTypeNormal, "InstanceRefreshSuccessful",
+			fmt.Sprintf("Replaced all %s nodes. %s", nodes, state.Summary()))
+	} else if (phase == refresh.PhasePending || phase == refresh.PhaseQueued) && state.Phase == refresh.PhaseInProgress {
+		r.recorder.Event(instanceRefresh, v1.EventTypeNormal, "InstanceRefreshIsStarting",
+			fmt.Sprintf("Starting to replace all %s nodes.", nodes))
+	}
+
+	return r.updateStatus(ctx, logger, instanceRefresh, status)
+}
+
+// fail finishes the instance refresh with the given message.
+func (r *InstanceRefreshReconciler) fail(ctx context.Context, logger logr.Logger, instanceRefresh *v1alpha1.InstanceRefresh, status *v1alpha1.InstanceRefreshStatus, message string) (ctrl.Result, error) {
+	now := metav1.Now()
+	if !finished(instanceRefresh.Status.Phase) {
+		instanceRefresh.Status.Phase = string(refresh.PhaseFailed)
+	}
+	if instanceRefresh.Status.StartTime == nil {
+		instanceRefresh.Status.StartTime = &now
+	}
+	instanceRefresh.Status.EndTime = &now
+	instanceRefresh.Status.Message = message
+	r.recorder.Event(instanceRefresh, v1.EventTypeWarning, "InstanceRefreshCancelled", message)
+
+	return r.updateStatus(ctx, logger, instanceRefresh, status)
+}
+
+// updateStatus sets the conditions of the given InstanceRefresh, persists its
+// status if it changed and requeues it until it finished. Finished instance
+// refreshes are recorded in the history of their cluster first.
+func (r *InstanceRefreshReconciler) updateStatus(ctx context.Context, logger logr.Logger, instanceRefresh *v1alpha1.InstanceRefresh, status *v1alpha1.InstanceRefreshStatus) (ctrl.Result, error) {
+	setConditions(&instanceRefresh.Status, instanceRefresh.Generation)
+
+	if finished(instanceRefresh.Status.Phase) && !finished(status.Phase) {
+		err := r.recordHistory(ctx, logger, instanceRefresh)
+		if err != nil {
+			// The history is best effort, it must not keep the instance
+			// refresh from finishing.
+			logger.Error(err, "failed to record instance refresh history")
+		}
+	}
+
+	result := refreshRequeue()
+	if finished(instanceRefresh.Status.Phase) {
+		result = ctrl.Result{}
+	}
+	if equality.Semantic.DeepEqual(*status, instanceRefresh.Status) {
+		return result, nil
+	}
+
+	// The status describes what already happened in AWS, so it must not be
+	// dropped on conflicts. It is written onto the latest InstanceRefresh
+	// instead.
+	desired := instanceRefresh.Status
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		err := r.Status().Update(ctx, instanceRefresh)
+		if errors.IsConflict(err) {
+			getErr := r.Get(ctx, client.ObjectKeyFromObject(instanceRefresh), instanceRefresh)
+			if getErr != nil {
+				return getErr
+			}
+			instanceRefresh.Status = desired
+		}
+		return err
+	})
+	if err != nil {
+		logger.Error(err, "failed to update InstanceRefresh status")
+		return refreshRequeue(), microerror.Mask(err)
+	}
+	return result, nil
+}
+
+// target looks up the cluster and ASGs the InstanceRefresh refreshes.
+func (r *InstanceRefreshReconciler) target(ctx context.Context, instanceRefresh *v1alpha1.InstanceRefresh) (*targetScope, error) {
+	adapter := targetAdapters[instanceRefresh.Spec.TargetRef.Kind]
+	obj := adapter.newObject()
+	err := r.Get(ctx, types.NamespacedName{Name: instanceRefresh.Spec.TargetRef.Name, Namespace: instanceRefresh.Namespace}, obj)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	return adapter.scope(ctx, r.Client, obj, adapter.cluster(obj))
+}
+
+// invalidTarget returns true if the target of an InstanceRefresh can not be
+// refreshed until it got fixed, e.g. because it does not exist.
+func invalidTarget(err error) bool {
+	return errors.IsNotFound(err) || meta.IsNoMatchError(err) || IsInvalidTarget(err) || capi.IsInvalidConfig(err)
+}
+
+// preferences fills the settings left unset in the given spec with the
+// defaults of the operator. The maintenance window of the given cluster takes
+// precedence over the one of the operator.
+func (r *InstanceRefreshReconciler) preferences(spec v1alpha1.InstanceRefreshSpec, cluster client.Object) refresh.Preferences {
+	preferences := refresh.Preferences{
+		MinHealthyPercentage:      key.DefaultMinHealthyPercentage,
+		InstanceWarmupSeconds:     key.DefaultInstanceWarmupSeconds,
+		MaxParallelASGs:           r.MaxParallelASGs,
+		CheckpointPercentages:     spec.Checkpoints,
+		CheckpointDelaySeconds:    key.DefaultCheckpointDelaySeconds,
+		Surge:                     key.DefaultSurge,
+		Drain:                     r.Drain,
+		DrainTimeoutSeconds:       key.DefaultDrainTimeoutSeconds,
+		CheckPDBs:                 r.CheckPDBs,
+		CheckPDBsTimeoutSeconds:   key.DefaultCheckPDBsTimeoutSeconds,
+		HealthCheck:               r.HealthCheck,
+		HealthCheckTimeoutSeconds: key.DefaultHealthCheckTimeoutSeconds,
+		HealthQuery:               r.HealthQuery,
+		MaintenanceWindow:         r.MaintenanceWindow,
+		MaintenanceWindowPolicy:   r.MaintenanceWindowPolicy,
+		RequireApproval:           spec.RequireApproval,
+		ApprovedCheckpoint:        spec.ApprovedCheckpoint,
+		SkipMatching:              r.SkipMatching,
+		LaunchTemplateVersion:     spec.LaunchTemplateVersion,
+		Rollback:                  r.Rollback,
+		CooldownSeconds:           int64(r.RefreshCooldown.Seconds()),
+		Force:                     spec.Force,
+		Strategy:                  string(spec.Strategy),
+		Roller:                    string(spec.Roller),
+		Cancel:                    spec.Cancel,
+	}
+	if spec.MinHealthyPercentage != nil {
+		preferences.MinHealthyPercentage = *spec.MinHealthyPercentage
+	}
+	if spec.InstanceWarmupSeconds != nil {
+		preferences.InstanceWarmupSeconds = *spec.InstanceWarmupSeconds
+	}
+	if spec.MaxParallelASGs != nil {
+		preferences.MaxParallelASGs = *spec.MaxParallelASGs
+	}
+	if spec.CheckpointDelaySeconds != nil {
+		preferences.CheckpointDelaySeconds = *spec.CheckpointDelaySeconds
+	}
+	if spec.SkipMatching != nil {
+		preferences.SkipMatching = *spec.SkipMatching
+	}
+	if spec.Rollback != nil {
+		preferences.Rollback = *spec.Rollback
+	}
+	if spec.CooldownSeconds != nil {
+		preferences.CooldownSeconds = *spec.CooldownSeconds
+	}
+	if spec.Surge != nil {
+		preferences.Surge = *spec.Surge
+	}
+	if spec.MaxUnavailable != nil {
+		preferences.MaxUnavailable = *spec.MaxUnavailable
+	}
+	if spec.Drain != nil {
+		preferences.Drain = *spec.Drain
+	}
+	if spec.DrainTimeoutSeconds != nil {
+		preferences.DrainTimeoutSeconds = *spec.DrainTimeoutSeconds
+	}
+	if spec.CheckPDBs != nil {
+		preferences.CheckPDBs = *spec.CheckPDBs
+	}
+	if spec.CheckPDBsTimeoutSeconds != nil {
+		preferences.CheckPDBsTimeoutSeconds = *spec.CheckPDBsTimeoutSeconds
+	}
+	if spec.HealthCheck != nil {
+		preferences.HealthCheck = *spec.HealthCheck
+	}
+	if spec.HealthCheckTimeoutSeconds != nil {
+		preferences.HealthCheckTimeoutSeconds = *spec.HealthCheckTimeoutSeconds
+	}
+	if spec.HealthQuery != "" {
+		preferences.HealthQuery = spec.HealthQuery
+	}
+	if w := key.MaintenanceWindow(cluster); w != "" {
+		preferences.MaintenanceWindow = w
+	}
+	if policy := key.MaintenanceWindowPolicy(cluster); policy != "" {
+		preferences.MaintenanceWindowPolicy = policy
+	}
+	if spec.MaintenanceWindow != "" {
+		preferences.MaintenanceWindow = spec.MaintenanceWindow
+	}
+	if spec.MaintenanceWindowPolicy != "" {
+		preferences.MaintenanceWindowPolicy = string(spec.MaintenanceWindowPolicy)
+	}
+	return preferences
+}
+
+// validate checks the parts of the spec the CRD schema can not express.
+func validate(spec v1alpha1.InstanceRefreshSpec, prometheusAddress string) error {
+	if _, ok := targetAdapters[spec.TargetRef.Kind]; !ok {
+		return fmt.Errorf("Unsupported target kind %q", spec.TargetRef.Kind)
+	}
+
+	for i, c := range spec.Checkpoints {
+		if c > 100 || c < 1 {
+			return fmt.Errorf("Instance refresh checkpoints must be between 1 and 100, got %v", c)
+		}
+		if i > 0 && c <= spec.Checkpoints[i-1] {
+			return fmt.Errorf("Instance refresh checkpoints must be in ascending order, got %v", spec.Checkpoints)
+		}
+	}
+
+	// AWS only pauses at checkpoints for the checkpoint delay, which is
+	// when the operator holds the instance refresh for approval.
+	if spec.RequireApproval && spec.CheckpointDelaySeconds != nil && *spec.CheckpointDelaySeconds == 0 {
+		return fmt.Errorf("Instance refresh approval requires a checkpoint delay, AWS does not pause at checkpoints without one")
+	}
+
+	switch v := spec.LaunchTemplateVersion; v {
+	case "", "$Latest", "$Default":
+	default:
+		if n, err := strconv.Atoi(v); err != nil || n < 1 {
+			return fmt.Errorf("Launch template version must be a version number, $Latest or $Default, got %s", v)
+		}
+	}
+
+	if spec.HealthQuery != "" && prometheusAddress == "" {
+		return fmt.Errorf("Health query %q can not be evaluated, the operator has no Prometheus address", spec.HealthQuery)
+	}
+	return nil
+}
+
+func finished(phase string) bool {
+	switch refresh.Phase(phase) {
+	case refresh.PhaseSuccessful, refresh.PhaseFailed, refresh.PhaseCancelled:
+		return true
+	}
+	return false
+}
+
+// SetupWithManager sets up the controller with the Manager. Status updates
+// do not trigger reconciliations, the reconciler requeues itself while the
+// instance refresh is in progress.
+func (r *InstanceRefreshReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.recorder = mgr.GetEventRecorderFor("instancerefresh-controller")
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&v1alpha1.InstanceRefresh{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Complete(r)
+}
@@ -0,0 +1,48 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+
+	"github.com/giantswarm/aws-rolling-node-operator/api/v1alpha1"
+)
+
+func TestValidateRequireApproval(t *testing.T) {
+	testCases := []struct {
+		name                   string
+		checkpointDelaySeconds *int64
+		valid                  bool
+	}{
+		{name: "default delay", checkpointDelaySeconds: nil, valid: true},
+		{name: "delay", checkpointDelaySeconds: aws.Int64(600), valid: true},
+		{name: "no delay", checkpointDelaySeconds: aws.Int64(0), valid: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validate(v1alpha1.InstanceRefreshSpec{
+				TargetRef:              v1alpha1.TargetReference{Kind: "AWSMachineDeployment"},
+				Checkpoints:            []int64{50, 100},
+				CheckpointDelaySeconds: tc.checkpointDelaySeconds,
+				RequireApproval:        true,
+			}, "")
+			if (err == nil) != tc.valid {
+				t.Fatalf("expected valid %t, got %v", tc.valid, err)
+			}
+		})
+	}
+}
+
+func TestValidateHealthQuery(t *testing.T) {
+	spec := v1alpha1.InstanceRefreshSpec{
+		TargetRef:   v1alpha1.TargetReference{Kind: "AWSMachineDeployment"},
+		HealthQuery: `up{job="apiserver"} == 1`,
+	}
+	if err := validate(spec, ""); err == nil {
+		t.Fatal("expected a health query without Prometheus address to be invalid")
+	}
+	if err := validate(spec, "http://prometheus:9090"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,63 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/giantswarm/k8smetadata/pkg/annotation"
+	"github.com/robfig/cron/v3"
+	v1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/giantswarm/aws-rolling-node-operator/api/v1alpha1"
+	"github.com/giantswarm/aws-rolling-node-operator/pkg/key"
+)
+
+// schedule requests an instance refresh of the given CR by setting the
+// instance refresh annotation whenever a run of its schedule is due, and
+// records the next run in the next run annotation. The trigger annotation
+// tells the history the instance refresh apart from one requested by hand,
+// which it otherwise proceeds like, so Auto Scaling groups within their
+// cooldown are skipped.
+func (a *annotationCompatibility) schedule(ctx context.Context, obj client.Object, targetRef v1alpha1.TargetReference, schedule cron.Schedule) (ctrl.Result, error) {
+	recorded, err := key.InstanceRefreshNextRun(obj)
+	if err != nil {
+		a.logger.Info(fmt.Sprintf("Replacing invalid next run of the instance refresh schedule: %s", err))
+		recorded = time.Time{}
+	}
+	now := time.Now().UTC()
+	nextRun, due := nextScheduledRun(recorded, schedule, now)
+	requeue := ctrl.Result{RequeueAfter: nextRun.Sub(now)}
+
+	annotations := obj.GetAnnotations()
+	value := nextRun.Format(time.RFC3339)
+	if !due && annotations[key.InstanceRefreshNextRunAnnotation] == value {
+		return requeue, nil
+	}
+	annotations[key.InstanceRefreshNextRunAnnotation] = value
+	if due {
+		annotations[annotation.AWSInstanceRefresh] = "true"
+		annotations[key.InstanceRefreshTriggerAnnotation] = key.TriggerSchedule
+	}
+	obj.SetAnnotations(annotations)
+	err = a.update(ctx, obj)
+	if err != nil {
+		return defaultRequeue(), err
+	}
+
+	if due {
+		a.recorder.Event(obj, v1.EventTypeNormal, "InstanceRefreshScheduled",
+			fmt.Sprintf("Requesting the scheduled refresh of all %s nodes. The next one is scheduled for %s.", targetNodes(targetRef.Kind), value))
+	}
+	return requeue, nil
+}
+
+// nextScheduledRun returns the next run of the schedule after the given time
+// and whether the recorded run is due. Runs missed while the operator was down
+// or an instance refresh was still in progress are caught up once.
+func nextScheduledRun(recorded time.Time, schedule cron.Schedule, now time.Time) (time.Time, bool) {
+	due := !recorded.IsZero() && !recorded.After(now)
+	return schedule.Next(now), due
+}
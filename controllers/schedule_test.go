@@ -0,0 +1,41 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+func TestNextScheduledRun(t *testing.T) {
+	schedule, err := cron.ParseStandard("0 3 * * SUN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Saturday, the next run is on Sunday at 03:00.
+	now := time.Date(2022, 3, 5, 12, 0, 0, 0, time.UTC)
+	sunday := time.Date(2022, 3, 6, 3, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name     string
+		recorded time.Time
+		expected bool
+	}{
+		{name: "not recorded", recorded: time.Time{}, expected: false},
+		{name: "in the future", recorded: sunday, expected: false},
+		{name: "due", recorded: now, expected: true},
+		{name: "missed", recorded: now.Add(-7 * 24 * time.Hour), expected: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			nextRun, due := nextScheduledRun(tc.recorded, schedule, now)
+			if due != tc.expected {
+				t.Fatalf("expected due %t, got %t", tc.expected, due)
+			}
+			if !nextRun.Equal(sunday) {
+				t.Fatalf("expected next run %s, got %s", sunday, nextRun)
+			}
+		})
+	}
+}
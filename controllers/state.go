@@ -0,0 +1,45 @@
+package controllers
+
+import (
+	"time"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/giantswarm/aws-rolling-node-operator/api/v1alpha1"
+	"github.com/giantswarm/aws-rolling-node-operator/pkg/refresh"
+)
+
+// stateFromStatus restores the progress of an instance refresh from the
+// status of its InstanceRefresh, so the refresh resumes where it left off
+// after an operator restart. ASGStatus and refresh.ASGState share their
+// fields, so they convert into each other.
+func stateFromStatus(status v1alpha1.InstanceRefreshStatus) *refresh.State {
+	state := &refresh.State{Phase: refresh.Phase(status.Phase)}
+	if state.Phase == "" {
+		state.Phase = refresh.PhasePending
+	}
+	for _, a := range status.ASGs {
+		state.ASGs = append(state.ASGs, refresh.ASGState(a))
+	}
+	return state
+}
+
+// applyState records the progress of an instance refresh in the status of its
+// InstanceRefresh.
+func applyState(status *v1alpha1.InstanceRefreshStatus, state *refresh.State) {
+	status.Phase = string(state.Phase)
+	status.PercentageComplete = state.PercentageComplete()
+	status.ASGs = nil
+	for _, a := range state.ASGs {
+		status.ASGs = append(status.ASGs, v1alpha1.ASGStatus(a))
+	}
+}
+
+// refreshRequeue is used while an instance refresh is in progress.
+func refreshRequeue() reconcile.Result {
+	return ctrl.Result{
+		Requeue:      true,
+		RequeueAfter: time.Second * 30,
+	}
+}
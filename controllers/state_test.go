@@ -0,0 +1,76 @@
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/giantswarm/aws-rolling-node-operator/api/v1alpha1"
+	"github.com/giantswarm/aws-rolling-node-operator/pkg/refresh"
+)
+
+func TestStateRoundTrip(t *testing.T) {
+	status := v1alpha1.InstanceRefreshStatus{}
+	state := stateFromStatus(status)
+	if state.Phase != refresh.PhasePending || state.ASGs != nil {
+		t.Fatalf("expected new pending state, got %+v", state)
+	}
+
+	state.Phase = refresh.PhaseInProgress
+	state.ASGs = []refresh.ASGState{{Name: "asg-1", InstanceRefreshID: "id", Status: autoscaling.InstanceRefreshStatusInProgress}}
+	applyState(&status, state)
+
+	restored := stateFromStatus(status)
+	if !reflect.DeepEqual(state, restored) {
+		t.Fatalf("expected %+v, got %+v", state, restored)
+	}
+}
+
+func TestUpdateStatusRetriesConflicts(t *testing.T) {
+	s := runtime.NewScheme()
+	err := v1alpha1.AddToScheme(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	instanceRefresh := &v1alpha1.InstanceRefresh{ObjectMeta: metav1.ObjectMeta{Name: "abc12", Namespace: "org-example"}}
+	c := fake.NewClientBuilder().WithScheme(s).WithObjects(instanceRefresh).Build()
+	r := &InstanceRefreshReconciler{Client: c}
+	ctx := context.Background()
+
+	stale := &v1alpha1.InstanceRefresh{}
+	err = c.Get(ctx, client.ObjectKeyFromObject(instanceRefresh), stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The InstanceRefresh changes while a pass is in progress.
+	latest := stale.DeepCopy()
+	latest.Spec.Cancel = true
+	err = c.Update(ctx, latest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status := stale.Status.DeepCopy()
+	stale.Status.Phase = string(refresh.PhaseInProgress)
+	stale.Status.ASGs = []v1alpha1.ASGStatus{{Name: "asg-1", InstanceRefreshID: "refresh-1"}}
+	_, err = r.updateStatus(ctx, logr.Discard(), stale, status)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	persisted := &v1alpha1.InstanceRefresh{}
+	err = c.Get(ctx, client.ObjectKeyFromObject(instanceRefresh), persisted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(persisted.Status.ASGs) != 1 || persisted.Status.ASGs[0].InstanceRefreshID != "refresh-1" || !persisted.Spec.Cancel {
+		t.Fatalf("expected the status to be written onto the latest InstanceRefresh, got %+v", persisted)
+	}
+}
@@ -0,0 +1,174 @@
+package controllers
+
+import (
+	"context"
+
+	infrastructurev1alpha3 "github.com/giantswarm/apiextensions/v6/pkg/apis/infrastructure/v1alpha3"
+	"github.com/giantswarm/microerror"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/giantswarm/aws-rolling-node-operator/api/v1alpha1"
+	"github.com/giantswarm/aws-rolling-node-operator/pkg/capi"
+	"github.com/giantswarm/aws-rolling-node-operator/pkg/key"
+)
+
+// targetAdapter is everything the reconcilers need to know about a kind of CR
+// whose ASGs can be refreshed. Supporting a new kind only requires adding an
+// adapter for it to targetAdapters.
+type targetAdapter struct {
+	// name identifies the kind in the names of its controller, logger and
+	// event recorder.
+	name string
+	// gvk is only set for kinds whose CRD is optional. They are handled as
+	// unstructured objects.
+	gvk *schema.GroupVersionKind
+	// newObject returns an empty CR of the kind.
+	newObject func() client.Object
+	// cluster returns the name of the cluster the CR belongs to.
+	cluster func(obj client.Object) string
+	// scope looks up the AWS account of the given cluster of the CR and the
+	// ASGs of the CR.
+	scope func(ctx context.Context, c client.Client, obj client.Object, clusterName string) (*targetScope, error)
+	// nodes describes the nodes an instance refresh of the CR replaces.
+	nodes string
+}
+
+// targetScope is what an instance refresh needs to know about the cluster of
+// its target and the ASGs to refresh.
+type targetScope struct {
+	clusterName string
+	accountID   string
+	arn         string
+	region      string
+	// cluster is the infrastructure cluster CR, which owns the history.
+	cluster client.Object
+	// clusterTags select the ASGs of the cluster, filter the ASGs of the
+	// target among them. Nil selects all ASGs of the cluster.
+	clusterTags map[string]string
+	filter      map[string]string
+}
+
+var targetAdapters = map[string]targetAdapter{
+	v1alpha1.TargetKindAWSCluster: {
+		name:      "cluster",
+		newObject: func() client.Object { return &infrastructurev1alpha3.AWSCluster{} },
+		cluster:   func(obj client.Object) string { return obj.GetName() },
+		scope:     legacyScope(func(obj client.Object) map[string]string { return nil }),
+		nodes:     "master and worker",
+	},
+	v1alpha1.TargetKindAWSControlPlane: {
+		name:      "controlplane",
+		newObject: func() client.Object { return &infrastructurev1alpha3.AWSControlPlane{} },
+		cluster:   func(obj client.Object) string { return key.Cluster(obj) },
+		scope: legacyScope(func(obj client.Object) map[string]string {
+			return map[string]string{key.ControlPlaneLabel: key.Controlplane(obj)}
+		}),
+		nodes: "master",
+	},
+	v1alpha1.TargetKindAWSMachineDeployment: {
+		name:      "machinedeployment",
+		newObject: func() client.Object { return &infrastructurev1alpha3.AWSMachineDeployment{} },
+		cluster:   func(obj client.Object) string { return key.Cluster(obj) },
+		scope: legacyScope(func(obj client.Object) map[string]string {
+			return map[string]string{key.MachineDeploymentLabel: key.MachineDeployment(obj)}
+		}),
+		nodes: "worker",
+	},
+	v1alpha1.TargetKindMachinePool:           capiAdapter("machinepool", capi.MachinePoolGVK),
+	v1alpha1.TargetKindAWSMachinePool:        capiAdapter("awsmachinepool", capi.AWSMachinePoolGVK),
+	v1alpha1.TargetKindAWSManagedMachinePool: capiAdapter("awsmanagedmachinepool", capi.AWSManagedMachinePoolGVK),
+}
+
+// legacyScope looks up the scope of Giant Swarm CRs. Their ASGs are tagged
+// with the labels of the CRs, which the given filter selects them by.
+func legacyScope(filter func(obj client.Object) map[string]string) func(context.Context, client.Client, client.Object, string) (*targetScope, error) {
+	return func(ctx context.Context, c client.Client, obj client.Object, clusterName string) (*targetScope, error) {
+		cluster := &infrastructurev1alpha3.AWSCluster{}
+		err := c.Get(ctx, types.NamespacedName{Name: clusterName, Namespace: obj.GetNamespace()}, cluster)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+
+		accountID, arn, err := key.AWSAccountDetails(ctx, c, cluster)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+		return &targetScope{
+			clusterName: cluster.Name,
+			accountID:   accountID,
+			arn:         arn,
+			region:      cluster.Spec.Provider.Region,
+			cluster:     cluster,
+			clusterTags: map[string]string{key.ClusterLabel: cluster.Name},
+			filter:      filter(obj),
+		}, nil
+	}
+}
+
+// capiAdapter returns the adapter of a Cluster API kind backed by a single
+// ASG.
+func capiAdapter(name string, gvk schema.GroupVersionKind) targetAdapter {
+	return targetAdapter{
+		name:      name,
+		gvk:       &gvk,
+		newObject: func() client.Object { return capi.New(gvk) },
+		cluster:   capi.ClusterName,
+		scope:     capiScope,
+		nodes:     "worker",
+	}
+}
+
+// capiScope looks up the scope of MachinePools and their AWSMachinePools and
+// AWSManagedMachinePools. The ASG of an AWSMachinePool is selected by its
+// name and the cluster tag of CAPA, the one of an AWSManagedMachinePool by
+// the tags EKS sets on the ASGs of managed node groups.
+func capiScope(ctx context.Context, c client.Client, obj client.Object, clusterName string) (*targetScope, error) {
+	pool, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, microerror.Maskf(invalidTargetError, "%T is no Cluster API object", obj)
+	}
+	if pool.GroupVersionKind() == capi.MachinePoolGVK {
+		var err error
+		pool, err = capi.MachinePoolInfrastructure(ctx, c, pool)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+	}
+
+	account, err := capi.ClusterAccount(ctx, c, obj.GetNamespace(), clusterName)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	scope := &targetScope{
+		clusterName: clusterName,
+		accountID:   account.AccountID(),
+		arn:         account.RoleARN,
+		region:      account.Region,
+		cluster:     account.InfrastructureCluster,
+	}
+
+	switch pool.GetKind() {
+	case capi.AWSMachinePoolGVK.Kind:
+		scope.clusterTags = map[string]string{capi.ClusterTagKey(clusterName): "owned"}
+		scope.filter = map[string]string{capi.NameTag: capi.ASGName(pool)}
+	case capi.AWSManagedMachinePoolGVK.Kind:
+		nodegroup := capi.EKSNodegroupName(pool)
+		if account.EKSClusterName == "" || nodegroup == "" {
+			return nil, microerror.Maskf(invalidTargetError, "EKS node group of %s %s is unknown", pool.GetKind(), pool.GetName())
+		}
+		scope.clusterTags = map[string]string{capi.EKSClusterNameTag: account.EKSClusterName}
+		scope.filter = map[string]string{capi.EKSNodegroupNameTag: nodegroup}
+	default:
+		return nil, microerror.Maskf(invalidTargetError, "Unsupported infrastructure %s of MachinePool %s", pool.GetKind(), obj.GetName())
+	}
+	return scope, nil
+}
+
+// targetNodes describes the nodes the instance refresh of the given target
+// kind replaces.
+func targetNodes(kind string) string {
+	return targetAdapters[kind].nodes
+}
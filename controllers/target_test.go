@@ -0,0 +1,202 @@
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	infrastructurev1alpha3 "github.com/giantswarm/apiextensions/v6/pkg/apis/infrastructure/v1alpha3"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/giantswarm/aws-rolling-node-operator/api/v1alpha1"
+	"github.com/giantswarm/aws-rolling-node-operator/pkg/capi"
+	"github.com/giantswarm/aws-rolling-node-operator/pkg/key"
+)
+
+func newUnstructured(apiVersion, kind, name string, spec map[string]interface{}) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+	obj.SetAPIVersion(apiVersion)
+	obj.SetKind(kind)
+	obj.SetName(name)
+	if kind != capi.AWSClusterRoleIdentityGVK.Kind {
+		obj.SetNamespace("org-example")
+	}
+	obj.SetLabels(map[string]string{capi.ClusterNameLabel: "abc12"})
+	return obj
+}
+
+func ref(apiVersion, kind, name string) map[string]interface{} {
+	return map[string]interface{}{"apiVersion": apiVersion, "kind": kind, "name": name}
+}
+
+func TestTargetScope(t *testing.T) {
+	s := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(s)
+	_ = infrastructurev1alpha3.AddToScheme(s)
+
+	awsCluster := &infrastructurev1alpha3.AWSCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "abc12", Namespace: "org-example"},
+	}
+	awsCluster.Spec.Provider.Region = "eu-west-1"
+	awsCluster.Spec.Provider.CredentialSecret.Name = "credential"
+	awsCluster.Spec.Provider.CredentialSecret.Namespace = "giantswarm"
+	objects := []client.Object{
+		awsCluster,
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "credential", Namespace: "giantswarm"},
+			Data:       map[string][]byte{"aws.awsoperator.arn": []byte("arn:aws:iam::123456789012:role/GiantSwarmAWSOperator")},
+		},
+		&infrastructurev1alpha3.AWSMachineDeployment{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "np0",
+				Namespace: "org-example",
+				Labels:    map[string]string{key.ClusterLabel: "abc12", key.MachineDeploymentLabel: "np0"},
+			},
+		},
+		newUnstructured("cluster.x-k8s.io/v1beta1", "Cluster", "abc12", map[string]interface{}{
+			"infrastructureRef": ref("infrastructure.cluster.x-k8s.io/v1beta1", "AWSCluster", "abc12"),
+		}),
+		newUnstructured("infrastructure.cluster.x-k8s.io/v1beta1", "AWSCluster", "abc12", map[string]interface{}{
+			"region":      "eu-central-1",
+			"identityRef": map[string]interface{}{"kind": "AWSClusterRoleIdentity", "name": "abc12"},
+		}),
+		newUnstructured("infrastructure.cluster.x-k8s.io/v1beta1", "AWSClusterRoleIdentity", "abc12", map[string]interface{}{
+			"roleARN": "arn:aws:iam::210987654321:role/capa-controller",
+		}),
+		newUnstructured("cluster.x-k8s.io/v1beta1", "MachinePool", "mp0", map[string]interface{}{
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"infrastructureRef": ref("infrastructure.cluster.x-k8s.io/v1beta1", "AWSMachinePool", "mp0"),
+				},
+			},
+		}),
+		newUnstructured("infrastructure.cluster.x-k8s.io/v1beta1", "AWSMachinePool", "mp0", map[string]interface{}{
+			"providerID": "arn:aws:autoscaling:eu-central-1:210987654321:autoScalingGroup:uuid:autoScalingGroupName/abc12-mp0",
+		}),
+		newUnstructured("cluster.x-k8s.io/v1beta1", "Cluster", "eks01", map[string]interface{}{
+			"infrastructureRef": ref("infrastructure.cluster.x-k8s.io/v1beta1", "AWSManagedCluster", "eks01"),
+			"controlPlaneRef":   ref("controlplane.cluster.x-k8s.io/v1beta1", "AWSManagedControlPlane", "eks01"),
+		}),
+		newUnstructured("infrastructure.cluster.x-k8s.io/v1beta1", "AWSManagedCluster", "eks01", map[string]interface{}{}),
+		newUnstructured("controlplane.cluster.x-k8s.io/v1beta1", "AWSManagedControlPlane", "eks01", map[string]interface{}{
+			"region":         "us-east-1",
+			"eksClusterName": "org-example_eks01",
+			"identityRef":    map[string]interface{}{"kind": "AWSClusterRoleIdentity", "name": "abc12"},
+		}),
+		newUnstructured("cluster.x-k8s.io/v1beta1", "Cluster", "ctl01", map[string]interface{}{
+			"infrastructureRef": ref("infrastructure.cluster.x-k8s.io/v1beta1", "AWSCluster", "ctl01"),
+		}),
+		newUnstructured("infrastructure.cluster.x-k8s.io/v1beta1", "AWSCluster", "ctl01", map[string]interface{}{
+			"region":      "eu-west-2",
+			"identityRef": map[string]interface{}{"kind": "AWSClusterControllerIdentity", "name": "default"},
+		}),
+		newUnstructured("cluster.x-k8s.io/v1beta1", "Cluster", "ctl02", map[string]interface{}{
+			"infrastructureRef": ref("infrastructure.cluster.x-k8s.io/v1beta1", "AWSCluster", "ctl02"),
+		}),
+		newUnstructured("infrastructure.cluster.x-k8s.io/v1beta1", "AWSCluster", "ctl02", map[string]interface{}{
+			"region": "eu-west-3",
+		}),
+		newUnstructured("infrastructure.cluster.x-k8s.io/v1beta1", "AWSManagedMachinePool", "ng0", map[string]interface{}{
+			"eksNodegroupName": "org-example_eks01-ng0",
+		}),
+	}
+	c := fake.NewClientBuilder().WithScheme(s).WithObjects(objects...).Build()
+
+	testCases := []struct {
+		name                string
+		kind                string
+		target              string
+		cluster             string
+		expectedRegion      string
+		expectedAccountID   string
+		expectedARN         string
+		expectedClusterTags map[string]string
+		expectedFilter      map[string]string
+	}{
+		{
+			name:                "AWSMachineDeployment",
+			kind:                v1alpha1.TargetKindAWSMachineDeployment,
+			target:              "np0",
+			expectedRegion:      "eu-west-1",
+			expectedAccountID:   "123456789012",
+			expectedARN:         "arn:aws:iam::123456789012:role/GiantSwarmAWSOperator",
+			expectedClusterTags: map[string]string{key.ClusterLabel: "abc12"},
+			expectedFilter:      map[string]string{key.MachineDeploymentLabel: "np0"},
+		},
+		{
+			name:                "MachinePool",
+			kind:                v1alpha1.TargetKindMachinePool,
+			target:              "mp0",
+			expectedRegion:      "eu-central-1",
+			expectedAccountID:   "210987654321",
+			expectedARN:         "arn:aws:iam::210987654321:role/capa-controller",
+			expectedClusterTags: map[string]string{capi.ClusterTagKey("abc12"): "owned"},
+			expectedFilter:      map[string]string{capi.NameTag: "abc12-mp0"},
+		},
+		{
+			name:                "AWSManagedMachinePool",
+			kind:                v1alpha1.TargetKindAWSManagedMachinePool,
+			target:              "ng0",
+			cluster:             "eks01",
+			expectedRegion:      "us-east-1",
+			expectedAccountID:   "210987654321",
+			expectedARN:         "arn:aws:iam::210987654321:role/capa-controller",
+			expectedClusterTags: map[string]string{capi.EKSClusterNameTag: "org-example_eks01"},
+			expectedFilter:      map[string]string{capi.EKSNodegroupNameTag: "org-example_eks01-ng0"},
+		},
+		{
+			name:                "MachinePool with controller identity",
+			kind:                v1alpha1.TargetKindMachinePool,
+			target:              "mp0",
+			cluster:             "ctl01",
+			expectedRegion:      "eu-west-2",
+			expectedClusterTags: map[string]string{capi.ClusterTagKey("ctl01"): "owned"},
+			expectedFilter:      map[string]string{capi.NameTag: "abc12-mp0"},
+		},
+		{
+			name:                "MachinePool without identity",
+			kind:                v1alpha1.TargetKindMachinePool,
+			target:              "mp0",
+			cluster:             "ctl02",
+			expectedRegion:      "eu-west-3",
+			expectedClusterTags: map[string]string{capi.ClusterTagKey("ctl02"): "owned"},
+			expectedFilter:      map[string]string{capi.NameTag: "abc12-mp0"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			adapter := targetAdapters[tc.kind]
+			obj := adapter.newObject()
+			err := c.Get(context.Background(), types.NamespacedName{Name: tc.target, Namespace: "org-example"}, obj)
+			if err != nil {
+				t.Fatal(err)
+			}
+			clusterName := adapter.cluster(obj)
+			if tc.cluster != "" {
+				clusterName = tc.cluster
+			}
+
+			scope, err := adapter.scope(context.Background(), c, obj, clusterName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if scope.region != tc.expectedRegion || scope.accountID != tc.expectedAccountID {
+				t.Fatalf("expected account %s in %s, got %s in %s", tc.expectedAccountID, tc.expectedRegion, scope.accountID, scope.region)
+			}
+			if scope.arn != tc.expectedARN {
+				t.Fatalf("expected role %q, got %q", tc.expectedARN, scope.arn)
+			}
+			if !reflect.DeepEqual(scope.clusterTags, tc.expectedClusterTags) || !reflect.DeepEqual(scope.filter, tc.expectedFilter) {
+				t.Fatalf("expected ASGs selected by %v and %v, got %v and %v", tc.expectedClusterTags, tc.expectedFilter, scope.clusterTags, scope.filter)
+			}
+		})
+	}
+}
@@ -15,7 +15,10 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	infrastructurev1alpha3 "github.com/giantswarm/apiextensions/v6/pkg/apis/infrastructure/v1alpha3"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -26,7 +29,9 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
+	awsv1alpha1 "github.com/giantswarm/aws-rolling-node-operator/api/v1alpha1"
 	"github.com/giantswarm/aws-rolling-node-operator/controllers"
+	"github.com/giantswarm/aws-rolling-node-operator/pkg/window"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -38,6 +43,7 @@ var (
 func init() {
 	_ = clientgoscheme.AddToScheme(scheme)
 	_ = infrastructurev1alpha3.AddToScheme(scheme)
+	_ = awsv1alpha1.AddToScheme(scheme)
 	// +kubebuilder:scaffold:scheme
 }
 
@@ -46,8 +52,30 @@ func main() {
 	var enableLeaderElection bool
 	var probeAddr string
 	var installation string
+	var maxParallelASGs int64
+	var skipMatching bool
+	var rollback bool
+	var drain bool
+	var checkPDBs bool
+	var healthCheck bool
+	var prometheusAddress string
+	var healthQuery string
+	var maintenanceWindow string
+	var maintenanceWindowPolicy string
+	var refreshCooldown time.Duration
 
 	flag.StringVar(&installation, "installation", "", "The name of the installation.")
+	flag.Int64Var(&maxParallelASGs, "max-parallel-asgs", 1, "The maximum number of node pool ASGs refreshed at the same time.")
+	flag.BoolVar(&skipMatching, "skip-matching", false, "Skip replacing instances which already run the desired launch template version.")
+	flag.DurationVar(&refreshCooldown, "refresh-cooldown", 30*time.Minute, "The time after the last instance refresh of an ASG during which it is not refreshed again.")
+	flag.BoolVar(&rollback, "rollback", false, "Roll instances back to the previous launch template version if an instance refresh fails.")
+	flag.BoolVar(&drain, "drain", false, "Drain the nodes of the instances an instance refresh terminates.")
+	flag.BoolVar(&healthCheck, "health-check", false, "Hold back the next ASGs until the workload cluster is healthy after refreshing an ASG.")
+	flag.StringVar(&prometheusAddress, "prometheus-address", "", "The address of the Prometheus HTTP API health queries are evaluated against, e.g. http://prometheus:9090.")
+	flag.StringVar(&healthQuery, "health-query", "", "A PromQL expression which has to evaluate to true before an ASG gets refreshed and at every checkpoint.")
+	flag.StringVar(&maintenanceWindow, "maintenance-window", "", "The times ASGs get started in, e.g. \"Mon-Fri 22:00-05:00 Europe/Berlin\". Instance refreshes are queued outside of it.")
+	flag.StringVar(&maintenanceWindowPolicy, "maintenance-window-policy", string(awsv1alpha1.FinishMaintenanceWindowPolicy), "What happens to instance refreshes in progress when the maintenance window closes, Finish or Cancel.")
+	flag.BoolVar(&checkPDBs, "check-pdbs", false, "Delay instance refreshes while PodDisruptionBudgets allow no disruptions of pods on the nodes.")
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
@@ -61,6 +89,23 @@ func main() {
 
 	ctrl.SetLogger(klogr.New())
 
+	if healthQuery != "" && prometheusAddress == "" {
+		setupLog.Error(fmt.Errorf("--health-query requires --prometheus-address"), "invalid health query")
+		os.Exit(1)
+	}
+	if maintenanceWindow != "" {
+		if _, err := window.Parse(maintenanceWindow); err != nil {
+			setupLog.Error(err, "invalid maintenance window")
+			os.Exit(1)
+		}
+	}
+	switch awsv1alpha1.MaintenanceWindowPolicy(maintenanceWindowPolicy) {
+	case awsv1alpha1.FinishMaintenanceWindowPolicy, awsv1alpha1.CancelMaintenanceWindowPolicy:
+	default:
+		setupLog.Error(fmt.Errorf("unknown policy %q", maintenanceWindowPolicy), "invalid maintenance window policy")
+		os.Exit(1)
+	}
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:                 scheme,
 		MetricsBindAddress:     metricsAddr,
@@ -74,31 +119,42 @@ func main() {
 		os.Exit(1)
 	}
 
-	if err = (&controllers.LegacyClusterReconciler{
-		Client:       mgr.GetClient(),
-		Log:          ctrl.Log.WithName("legacy-cluster-controller"),
-		Scheme:       mgr.GetScheme(),
-		Installation: installation,
-	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "Cluster")
-		os.Exit(1)
-	}
-	if err = (&controllers.LegacyMachineDeploymentReconciler{
-		Client:       mgr.GetClient(),
-		Log:          ctrl.Log.WithName("legacy-machinedeployment-controller"),
-		Scheme:       mgr.GetScheme(),
-		Installation: installation,
-	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "MachineDeployment")
-		os.Exit(1)
+	for _, kind := range []string{
+		awsv1alpha1.TargetKindAWSCluster,
+		awsv1alpha1.TargetKindAWSMachineDeployment,
+		awsv1alpha1.TargetKindAWSControlPlane,
+		awsv1alpha1.TargetKindMachinePool,
+		awsv1alpha1.TargetKindAWSMachinePool,
+		awsv1alpha1.TargetKindAWSManagedMachinePool,
+	} {
+		if err = (&controllers.AnnotationReconciler{
+			Client: mgr.GetClient(),
+			Log:    ctrl.Log.WithName(fmt.Sprintf("%s-controller", strings.ToLower(kind))),
+			Scheme: mgr.GetScheme(),
+			Kind:   kind,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", kind)
+			os.Exit(1)
+		}
 	}
-	if err = (&controllers.LegacyControlplaneReconciler{
-		Client:       mgr.GetClient(),
-		Log:          ctrl.Log.WithName("legacy-controlplane-controller"),
-		Scheme:       mgr.GetScheme(),
-		Installation: installation,
+	if err = (&controllers.InstanceRefreshReconciler{
+		Client:                  mgr.GetClient(),
+		Log:                     ctrl.Log.WithName("instancerefresh-controller"),
+		Scheme:                  mgr.GetScheme(),
+		Installation:            installation,
+		MaxParallelASGs:         maxParallelASGs,
+		SkipMatching:            skipMatching,
+		Rollback:                rollback,
+		Drain:                   drain,
+		CheckPDBs:               checkPDBs,
+		HealthCheck:             healthCheck,
+		PrometheusAddress:       prometheusAddress,
+		HealthQuery:             healthQuery,
+		MaintenanceWindow:       maintenanceWindow,
+		MaintenanceWindowPolicy: maintenanceWindowPolicy,
+		RefreshCooldown:         refreshCooldown,
 	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "Controlplane")
+		setupLog.Error(err, "unable to create controller", "controller", "InstanceRefresh")
 		os.Exit(1)
 	}
 	// +kubebuilder:scaffold:builder
@@ -5,6 +5,9 @@ import (
 	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/eks"
+	"github.com/aws/aws-sdk-go/service/ssm"
 	"k8s.io/component-base/version"
 
 	"github.com/giantswarm/aws-rolling-node-operator/pkg/aws"
@@ -13,16 +16,52 @@ import (
 // AWSClients contains all the aws clients used by the scopes
 type AWSClients struct {
 	ASG *autoscaling.AutoScaling
+	EC2 *ec2.EC2
+	EKS *eks.EKS
+	SSM *ssm.SSM
 }
 
 // NewASGClient creates a new ASG API client for a given session
 func NewASGClient(session aws.Session, arn string) *autoscaling.AutoScaling {
-	ASGClient := autoscaling.New(session.Session(), &awsclient.Config{Credentials: stscreds.NewCredentials(session.Session(), arn)})
+	ASGClient := autoscaling.New(session.Session(), config(session, arn))
 	ASGClient.Handlers.Build.PushFrontNamed(getUserAgentHandler())
 
 	return ASGClient
 }
 
+// NewEC2Client creates a new EC2 API client for a given session
+func NewEC2Client(session aws.Session, arn string) *ec2.EC2 {
+	EC2Client := ec2.New(session.Session(), config(session, arn))
+	EC2Client.Handlers.Build.PushFrontNamed(getUserAgentHandler())
+
+	return EC2Client
+}
+
+// NewEKSClient creates a new EKS API client for a given session
+func NewEKSClient(session aws.Session, arn string) *eks.EKS {
+	EKSClient := eks.New(session.Session(), config(session, arn))
+	EKSClient.Handlers.Build.PushFrontNamed(getUserAgentHandler())
+
+	return EKSClient
+}
+
+// NewSSMClient creates a new SSM API client for a given session
+func NewSSMClient(session aws.Session, arn string) *ssm.SSM {
+	SSMClient := ssm.New(session.Session(), config(session, arn))
+	SSMClient.Handlers.Build.PushFrontNamed(getUserAgentHandler())
+
+	return SSMClient
+}
+
+// config returns the config of clients assuming the given role. Without role
+// the credentials of the session are used.
+func config(session aws.Session, arn string) *awsclient.Config {
+	if arn == "" {
+		return &awsclient.Config{}
+	}
+	return &awsclient.Config{Credentials: stscreds.NewCredentials(session.Session(), arn)}
+}
+
 func getUserAgentHandler() request.NamedHandler {
 	return request.NamedHandler{
 		Name: "aws-rolling-node-operator/user-agent",
@@ -27,12 +27,9 @@ type ClusterScopeParams struct {
 // NewClusterScope creates a new Scope from the supplied parameters.
 // This is meant to be called for each reconcile iteration.
 func NewClusterScope(params ClusterScopeParams) (*ClusterScope, error) {
-	if params.AccountID == "" {
+	if params.ARN != "" && params.AccountID == "" {
 		return nil, errors.New("failed to generate new scope from emtpy string AccountID")
 	}
-	if params.ARN == "" {
-		return nil, errors.New("failed to generate new scope from emtpy string ARN")
-	}
 	if params.ClusterName == "" {
 		return nil, errors.New("failed to generate new scope from emtpy string ClusterName")
 	}
@@ -54,13 +51,21 @@ func NewClusterScope(params ClusterScopeParams) (*ClusterScope, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create aws session")
 	}
-	awsClientConfig := &aws.Config{Credentials: stscreds.NewCredentials(session, params.ARN)}
+	// Without ARN the credentials of the operator are used, the account ID
+	// is the one of the operator then.
+	awsClientConfig := &aws.Config{}
+	if params.ARN != "" {
+		awsClientConfig.Credentials = stscreds.NewCredentials(session, params.ARN)
+	}
 
 	stsClient := sts.New(session, awsClientConfig)
-	_, err = stsClient.GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	identity, err := stsClient.GetCallerIdentity(&sts.GetCallerIdentityInput{})
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get sts client")
 	}
+	if params.AccountID == "" {
+		params.AccountID = aws.StringValue(identity.Account)
+	}
 
 	return &ClusterScope{
 		accountID:        params.AccountID,
@@ -93,7 +98,8 @@ func (s *ClusterScope) AccountID() string {
 	return s.accountID
 }
 
-// ARN returns the AWS SDK assumed role.
+// ARN returns the AWS SDK assumed role. It is empty if the credentials of the
+// operator are used.
 func (s *ClusterScope) ARN() string {
 	return s.assumeRole
 }
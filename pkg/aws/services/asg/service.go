@@ -2,6 +2,7 @@ package asg
 
 import (
 	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 
 	"github.com/giantswarm/aws-rolling-node-operator/pkg/aws/scope"
 )
@@ -10,6 +11,8 @@ import (
 type Service struct {
 	scope  scope.ASGScope
 	Client autoscalingiface.AutoScalingAPI
+	// EC2 resolves the launch template versions of ASGs.
+	EC2 ec2iface.EC2API
 }
 
 // NewService returns a new service given the S3 api client.
@@ -17,5 +20,6 @@ func NewService(clusterScope scope.ASGScope) *Service {
 	return &Service{
 		scope:  clusterScope,
 		Client: scope.NewASGClient(clusterScope, clusterScope.ARN()),
+		EC2:    scope.NewEC2Client(clusterScope, clusterScope.ARN()),
 	}
 }
@@ -0,0 +1,29 @@
+package eks
+
+import (
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/eks/eksiface"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+
+	"github.com/giantswarm/aws-rolling-node-operator/pkg/aws/scope"
+)
+
+// Service holds a collection of interfaces.
+type Service struct {
+	scope  scope.ASGScope
+	Client eksiface.EKSAPI
+	// EC2 resolves the launch template versions of node groups.
+	EC2 ec2iface.EC2API
+	// SSM resolves the latest AMI releases of node groups.
+	SSM ssmiface.SSMAPI
+}
+
+// NewService returns a new service given the EKS, EC2 and SSM api clients.
+func NewService(clusterScope scope.ASGScope) *Service {
+	return &Service{
+		scope:  clusterScope,
+		Client: scope.NewEKSClient(clusterScope, clusterScope.ARN()),
+		EC2:    scope.NewEC2Client(clusterScope, clusterScope.ARN()),
+		SSM:    scope.NewSSMClient(clusterScope, clusterScope.ARN()),
+	}
+}
@@ -0,0 +1,192 @@
+// Package capi reads the Cluster API and CAPA CRs the operator supports. They
+// are handled as unstructured objects, so the operator neither depends on the
+// Cluster API modules nor requires their CRDs to be installed.
+package capi
+
+import (
+	"context"
+	"strings"
+
+	"github.com/giantswarm/microerror"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// ClusterNameLabel is set on all Cluster API CRs of a cluster.
+	ClusterNameLabel = "cluster.x-k8s.io/cluster-name"
+	// ClusterTagKeyPrefix is the prefix of the tag CAPA sets on all AWS
+	// resources it owns, followed by the name of the cluster.
+	ClusterTagKeyPrefix = "sigs.k8s.io/cluster-api-provider-aws/cluster/"
+	// NameTag is the tag CAPA sets to the name of the ASGs it creates.
+	NameTag = "Name"
+	// EKSClusterNameTag and EKSNodegroupNameTag are set by EKS on the ASGs of
+	// managed node groups.
+	EKSClusterNameTag   = "eks:cluster-name"
+	EKSNodegroupNameTag = "eks:nodegroup-name"
+
+	asgNamePrefix = "autoScalingGroupName/"
+)
+
+var (
+	ClusterGVK                      = schema.GroupVersionKind{Group: "cluster.x-k8s.io", Version: "v1beta1", Kind: "Cluster"}
+	MachinePoolGVK                  = schema.GroupVersionKind{Group: "cluster.x-k8s.io", Version: "v1beta1", Kind: "MachinePool"}
+	AWSMachinePoolGVK               = schema.GroupVersionKind{Group: "infrastructure.cluster.x-k8s.io", Version: "v1beta1", Kind: "AWSMachinePool"}
+	AWSManagedMachinePoolGVK        = schema.GroupVersionKind{Group: "infrastructure.cluster.x-k8s.io", Version: "v1beta1", Kind: "AWSManagedMachinePool"}
+	AWSClusterRoleIdentityGVK       = schema.GroupVersionKind{Group: "infrastructure.cluster.x-k8s.io", Version: "v1beta1", Kind: "AWSClusterRoleIdentity"}
+	AWSClusterControllerIdentityGVK = schema.GroupVersionKind{Group: "infrastructure.cluster.x-k8s.io", Version: "v1beta1", Kind: "AWSClusterControllerIdentity"}
+)
+
+// Account is the AWS account and region the ASGs of a cluster live in.
+type Account struct {
+	Region string
+	// RoleARN is empty for clusters in the account of the operator, which
+	// uses its own credentials then.
+	RoleARN string
+	// EKSClusterName is only set for EKS clusters.
+	EKSClusterName string
+	// InfrastructureCluster is the CR referenced as infrastructure of the
+	// Cluster, e.g. its AWSCluster.
+	InfrastructureCluster *unstructured.Unstructured
+}
+
+// AccountID returns the ID of the AWS account the role ARN belongs to. It is
+// empty without role ARN.
+func (a *Account) AccountID() string {
+	parts := strings.Split(a.RoleARN, ":")
+	if len(parts) < 5 {
+		return ""
+	}
+	return parts[4]
+}
+
+// New returns an empty unstructured object of the given kind.
+func New(gvk schema.GroupVersionKind) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(gvk)
+	return obj
+}
+
+// ClusterName returns the name of the cluster the given CR belongs to.
+func ClusterName(obj client.Object) string {
+	return obj.GetLabels()[ClusterNameLabel]
+}
+
+// ClusterTagKey returns the key of the tag CAPA sets on the AWS resources of
+// the given cluster.
+func ClusterTagKey(clusterName string) string {
+	return ClusterTagKeyPrefix + clusterName
+}
+
+// MachinePoolInfrastructure returns the AWSMachinePool or
+// AWSManagedMachinePool referenced as infrastructure of the given MachinePool.
+func MachinePoolInfrastructure(ctx context.Context, c client.Client, machinePool *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	return reference(ctx, c, machinePool, "spec", "template", "spec", "infrastructureRef")
+}
+
+// ASGName returns the name of the ASG backing the given AWSMachinePool. It is
+// taken from the ASG ARN CAPA records as provider ID, CAPA names the ASG after
+// the AWSMachinePool until it got created.
+func ASGName(awsMachinePool *unstructured.Unstructured) string {
+	providerID, _, _ := unstructured.NestedString(awsMachinePool.Object, "spec", "providerID")
+	if i := strings.Index(providerID, asgNamePrefix); i >= 0 {
+		return providerID[i+len(asgNamePrefix):]
+	}
+	return awsMachinePool.GetName()
+}
+
+// EKSNodegroupName returns the name of the EKS node group backing the given
+// AWSManagedMachinePool.
+func EKSNodegroupName(awsManagedMachinePool *unstructured.Unstructured) string {
+	name, _, _ := unstructured.NestedString(awsManagedMachinePool.Object, "spec", "eksNodegroupName")
+	return name
+}
+
+// ClusterAccount looks up the AWS account and region of the given cluster.
+// They are read from the infrastructure cluster of the Cluster, falling back
+// to its control plane, which holds them for EKS clusters. The operator
+// assumes the role of an AWSClusterRoleIdentity to access the account. Like
+// CAPA, it uses its own credentials for clusters using the
+// AWSClusterControllerIdentity or no identity at all.
+func ClusterAccount(ctx context.Context, c client.Client, namespace, clusterName string) (*Account, error) {
+	cluster := New(ClusterGVK)
+	err := c.Get(ctx, types.NamespacedName{Name: clusterName, Namespace: namespace}, cluster)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	infrastructure, err := reference(ctx, c, cluster, "spec", "infrastructureRef")
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	sources := []*unstructured.Unstructured{infrastructure}
+	if _, ok, _ := unstructured.NestedMap(cluster.Object, "spec", "controlPlaneRef"); ok {
+		controlPlane, err := reference(ctx, c, cluster, "spec", "controlPlaneRef")
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+		sources = append(sources, controlPlane)
+	}
+
+	account := &Account{InfrastructureCluster: infrastructure}
+	var identityKind, identityName string
+	for _, s := range sources {
+		if account.Region == "" {
+			account.Region, _, _ = unstructured.NestedString(s.Object, "spec", "region")
+		}
+		if account.EKSClusterName == "" {
+			account.EKSClusterName, _, _ = unstructured.NestedString(s.Object, "spec", "eksClusterName")
+		}
+		if identityName == "" {
+			identityKind, _, _ = unstructured.NestedString(s.Object, "spec", "identityRef", "kind")
+			identityName, _, _ = unstructured.NestedString(s.Object, "spec", "identityRef", "name")
+		}
+	}
+	if account.Region == "" {
+		return nil, microerror.Maskf(invalidConfigError, "Region of cluster %s is unknown", clusterName)
+	}
+	if identityKind == "" || identityKind == AWSClusterControllerIdentityGVK.Kind {
+		return account, nil
+	}
+	if identityKind != AWSClusterRoleIdentityGVK.Kind || identityName == "" {
+		return nil, microerror.Maskf(invalidConfigError, "Cluster %s uses the unsupported identity %s %q", clusterName, identityKind, identityName)
+	}
+
+	identity := New(AWSClusterRoleIdentityGVK)
+	err = c.Get(ctx, types.NamespacedName{Name: identityName}, identity)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	account.RoleARN, _, _ = unstructured.NestedString(identity.Object, "spec", "roleARN")
+	if account.AccountID() == "" {
+		return nil, microerror.Maskf(invalidConfigError, "%s %s has no valid role ARN", AWSClusterRoleIdentityGVK.Kind, identityName)
+	}
+	return account, nil
+}
+
+// reference gets the CR referenced by the object reference at the given path
+// of obj. References without namespace point into the namespace of obj.
+func reference(ctx context.Context, c client.Client, obj *unstructured.Unstructured, path ...string) (*unstructured.Unstructured, error) {
+	ref, ok, err := unstructured.NestedStringMap(obj.Object, path...)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	if !ok || ref["apiVersion"] == "" || ref["kind"] == "" || ref["name"] == "" {
+		return nil, microerror.Maskf(invalidConfigError, "%s %s has no %s", obj.GetKind(), obj.GetName(), strings.Join(path, "."))
+	}
+
+	referenced := &unstructured.Unstructured{}
+	referenced.SetAPIVersion(ref["apiVersion"])
+	referenced.SetKind(ref["kind"])
+	namespace := ref["namespace"]
+	if namespace == "" {
+		namespace = obj.GetNamespace()
+	}
+	err = c.Get(ctx, types.NamespacedName{Name: ref["name"], Namespace: namespace}, referenced)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	return referenced, nil
+}
@@ -0,0 +1,17 @@
+package capi
+
+import (
+	"errors"
+
+	"github.com/giantswarm/microerror"
+)
+
+var invalidConfigError = &microerror.Error{
+	Kind: "invalidConfigError",
+}
+
+// IsInvalidConfig asserts invalidConfigError. It is returned for clusters
+// whose CRs lack what is needed to refresh their ASGs.
+func IsInvalidConfig(err error) bool {
+	return errors.Is(err, invalidConfigError)
+}
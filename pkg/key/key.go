@@ -5,13 +5,18 @@ import (
 	"fmt"
 	"regexp"
 	"strconv"
+	"strings"
+	"time"
 
 	infrastructurev1alpha3 "github.com/giantswarm/apiextensions/v6/pkg/apis/infrastructure/v1alpha3"
 	"github.com/giantswarm/k8smetadata/pkg/annotation"
 	"github.com/giantswarm/microerror"
+	"github.com/robfig/cron/v3"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/giantswarm/aws-rolling-node-operator/api/v1alpha1"
 )
 
 const (
@@ -20,9 +25,117 @@ const (
 	MachineDeploymentLabel = "giantswarm.io/machine-deployment"
 )
 
+const (
+	// InstanceRefreshNameAnnotation references the InstanceRefresh created
+	// for the instance refresh requested by annotations.
+	InstanceRefreshNameAnnotation = "alpha.aws.giantswarm.io/instance-refresh-name"
+	// InstanceRefreshStatusAnnotation holds the phase, progress and
+	// conditions of the last instance refresh requested by annotations.
+	InstanceRefreshStatusAnnotation = "alpha.aws.giantswarm.io/instance-refresh-status"
+	// InstanceRefreshPriorityAnnotation orders the node pools of a cluster
+	// wide instance refresh. Node pools with a lower value are refreshed first.
+	InstanceRefreshPriorityAnnotation = "alpha.aws.giantswarm.io/instance-refresh-priority"
+	// MaxParallelASGsAnnotation limits how many node pool ASGs are refreshed
+	// at the same time.
+	MaxParallelASGsAnnotation = "alpha.aws.giantswarm.io/max-parallel-asgs"
+	// InstanceRefreshCheckpointsAnnotation is a comma separated list of
+	// percentages at which the instance refresh pauses, e.g. "20,50,100".
+	InstanceRefreshCheckpointsAnnotation = "alpha.aws.giantswarm.io/instance-refresh-checkpoints"
+	// InstanceRefreshCheckpointDelaySecondsAnnotation is the time the
+	// instance refresh pauses at each checkpoint.
+	InstanceRefreshCheckpointDelaySecondsAnnotation = "alpha.aws.giantswarm.io/instance-refresh-checkpoint-delay-seconds"
+	// InstanceRefreshRequireApprovalAnnotation holds the instance refresh at
+	// every checkpoint until a human approves it.
+	InstanceRefreshRequireApprovalAnnotation = "alpha.aws.giantswarm.io/instance-refresh-require-approval"
+	// InstanceRefreshApproveCheckpointAnnotation approves all checkpoints up
+	// to the given percentage.
+	InstanceRefreshApproveCheckpointAnnotation = "alpha.aws.giantswarm.io/instance-refresh-approve-checkpoint"
+	// InstanceRefreshSkipMatchingAnnotation skips replacing instances which
+	// already run the desired launch template version.
+	InstanceRefreshSkipMatchingAnnotation = "alpha.aws.giantswarm.io/instance-refresh-skip-matching"
+	// InstanceRefreshLaunchTemplateVersionAnnotation pins the launch template
+	// version instances get replaced with, e.g. "12" or "$Default".
+	InstanceRefreshLaunchTemplateVersionAnnotation = "alpha.aws.giantswarm.io/instance-refresh-launch-template-version"
+	// InstanceRefreshRollbackAnnotation rolls instances back to the launch
+	// template version they ran before, if the instance refresh fails.
+	InstanceRefreshRollbackAnnotation = "alpha.aws.giantswarm.io/instance-refresh-rollback"
+	// InstanceRefreshCooldownSecondsAnnotation is the time after the last
+	// instance refresh of an ASG during which it is not refreshed again.
+	InstanceRefreshCooldownSecondsAnnotation = "alpha.aws.giantswarm.io/instance-refresh-cooldown-seconds"
+	// InstanceRefreshForceAnnotation refreshes ASGs regardless of the
+	// cooldown.
+	InstanceRefreshForceAnnotation = "alpha.aws.giantswarm.io/instance-refresh-force"
+	// InstanceRefreshMaxUnavailableAnnotation is the maximum number of nodes
+	// of an EKS managed node group which are unavailable during its update.
+	InstanceRefreshMaxUnavailableAnnotation = "alpha.aws.giantswarm.io/instance-refresh-max-unavailable"
+	// InstanceRefreshRollerAnnotation picks the mechanism replacing the
+	// instances instead of detecting it per ASG.
+	InstanceRefreshRollerAnnotation = "alpha.aws.giantswarm.io/instance-refresh-roller"
+	// InstanceRefreshSurgeAnnotation is the number of instances the Surge
+	// roller launches ahead of draining and terminating outdated ones.
+	InstanceRefreshSurgeAnnotation = "alpha.aws.giantswarm.io/instance-refresh-surge"
+	// InstanceRefreshDrainAnnotation drains the nodes of the instances an
+	// instance refresh terminates.
+	InstanceRefreshDrainAnnotation = "alpha.aws.giantswarm.io/instance-refresh-drain"
+	// InstanceRefreshDrainTimeoutSecondsAnnotation is the time after which
+	// instances get terminated even if their nodes are not drained yet.
+	InstanceRefreshDrainTimeoutSecondsAnnotation = "alpha.aws.giantswarm.io/instance-refresh-drain-timeout-seconds"
+	// InstanceRefreshCheckPDBsAnnotation delays instance refreshes while
+	// PodDisruptionBudgets allow no disruptions of pods on the nodes.
+	InstanceRefreshCheckPDBsAnnotation = "alpha.aws.giantswarm.io/instance-refresh-check-pdbs"
+	// InstanceRefreshCheckPDBsTimeoutSecondsAnnotation is the time after
+	// which an ASG fails if PodDisruptionBudgets keep delaying its instance
+	// refresh.
+	InstanceRefreshCheckPDBsTimeoutSecondsAnnotation = "alpha.aws.giantswarm.io/instance-refresh-check-pdbs-timeout-seconds"
+	// InstanceRefreshHealthCheckAnnotation holds back the next ASGs until
+	// the workload cluster is healthy after refreshing an ASG.
+	InstanceRefreshHealthCheckAnnotation = "alpha.aws.giantswarm.io/instance-refresh-health-check"
+	// InstanceRefreshHealthCheckTimeoutSecondsAnnotation is the time after
+	// which the instance refresh stops if the workload cluster is not healthy.
+	InstanceRefreshHealthCheckTimeoutSecondsAnnotation = "alpha.aws.giantswarm.io/instance-refresh-health-check-timeout-seconds"
+	// InstanceRefreshHealthQueryAnnotation is a PromQL expression which has
+	// to evaluate to true for the instance refresh to go on.
+	InstanceRefreshHealthQueryAnnotation = "alpha.aws.giantswarm.io/instance-refresh-health-query"
+	// InstanceRefreshScheduleAnnotation is a cron expression at whose times
+	// the operator requests instance refreshes itself, e.g. "0 3 * * SUN".
+	InstanceRefreshScheduleAnnotation = "alpha.aws.giantswarm.io/instance-refresh-schedule"
+	// InstanceRefreshNextRunAnnotation records the time of the next instance
+	// refresh requested by the schedule.
+	InstanceRefreshNextRunAnnotation = "alpha.aws.giantswarm.io/instance-refresh-next-run"
+	// InstanceRefreshTriggerAnnotation records what requested an instance
+	// refresh, i.e. TriggerSchedule. It is set on the annotated CR by the
+	// schedule and passed on to the InstanceRefresh.
+	InstanceRefreshTriggerAnnotation = "alpha.aws.giantswarm.io/instance-refresh-trigger"
+	// MaintenanceWindowAnnotation restricts the times instance refreshes of
+	// a cluster start ASGs in, e.g. "Mon-Fri 22:00-05:00 Europe/Berlin". It
+	// is set on the infrastructure cluster CR.
+	MaintenanceWindowAnnotation = "alpha.aws.giantswarm.io/maintenance-window"
+	// MaintenanceWindowPolicyAnnotation decides whether instance refreshes
+	// in progress finish the ASGs in flight or get cancelled when the
+	// maintenance window of the cluster closes.
+	MaintenanceWindowPolicyAnnotation = "alpha.aws.giantswarm.io/maintenance-window-policy"
+)
+
+// The sources an instance refresh can be triggered by, as recorded in its
+// history.
+const (
+	TriggerSchedule        = "schedule"
+	TriggerAnnotation      = "annotation"
+	TriggerInstanceRefresh = "InstanceRefresh"
+)
+
 var (
-	DefaultMinHealthyPercentage  int64 = 90
-	DefaultInstanceWarmupSeconds int64 = 0
+	DefaultMinHealthyPercentage    int64 = 90
+	DefaultInstanceWarmupSeconds   int64 = 0
+	DefaultInstanceRefreshPriority int64 = 0
+	// DefaultCheckpointDelaySeconds matches the default of AWS.
+	DefaultCheckpointDelaySeconds    int64 = 3600
+	DefaultSurge                     int64 = 1
+	DefaultDrainTimeoutSeconds       int64 = 600
+	DefaultCheckPDBsTimeoutSeconds   int64 = 3600
+	DefaultHealthCheckTimeoutSeconds int64 = 900
+
+	DefaultLaunchTemplateVersion = "$Latest"
 )
 
 func InstanceRefresh(getter AnnotationsGetter) bool {
@@ -75,6 +188,357 @@ func InstanceWarmupSeconds(getter AnnotationsGetter) (int64, error) {
 
 }
 
+func InstanceRefreshPriority(getter AnnotationsGetter) (int64, error) {
+	value, ok := getter.GetAnnotations()[InstanceRefreshPriorityAnnotation]
+	if !ok {
+		return DefaultInstanceRefreshPriority, nil
+	}
+	v, err := strconv.Atoi(value)
+	if err != nil {
+		return DefaultInstanceRefreshPriority, err
+	}
+	return int64(v), nil
+
+}
+
+func MaxParallelASGs(getter AnnotationsGetter, defaultMaxParallelASGs int64) (int64, error) {
+	value, ok := getter.GetAnnotations()[MaxParallelASGsAnnotation]
+	if !ok {
+		return defaultMaxParallelASGs, nil
+	}
+	v, err := strconv.Atoi(value)
+	if err != nil {
+		return defaultMaxParallelASGs, err
+	}
+	if v < 1 {
+		return defaultMaxParallelASGs,
+			fmt.Errorf("Maximum parallel ASGs must be 1 or higher, got %v. Ignoring CR",
+				v)
+	}
+	return int64(v), nil
+
+}
+
+func InstanceRefreshCheckpoints(getter AnnotationsGetter) ([]int64, error) {
+	value, ok := getter.GetAnnotations()[InstanceRefreshCheckpointsAnnotation]
+	if !ok || strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+
+	var checkpoints []int64
+	for _, s := range strings.Split(value, ",") {
+		v, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			return nil, err
+		}
+		if v > 100 || v < 1 {
+			return nil,
+				fmt.Errorf("Instance refresh checkpoints must be between 1 and 100, got %v. Ignoring CR",
+					v)
+		}
+		if len(checkpoints) > 0 && int64(v) <= checkpoints[len(checkpoints)-1] {
+			return nil,
+				fmt.Errorf("Instance refresh checkpoints must be in ascending order, got %s. Ignoring CR",
+					value)
+		}
+		checkpoints = append(checkpoints, int64(v))
+	}
+	return checkpoints, nil
+
+}
+
+func InstanceRefreshCheckpointDelaySeconds(getter AnnotationsGetter) (int64, error) {
+	value, ok := getter.GetAnnotations()[InstanceRefreshCheckpointDelaySecondsAnnotation]
+	if !ok {
+		return DefaultCheckpointDelaySeconds, nil
+	}
+	v, err := strconv.Atoi(value)
+	if err != nil {
+		return DefaultCheckpointDelaySeconds, err
+	}
+	if v > 172800 || v < 0 {
+		return DefaultCheckpointDelaySeconds,
+			fmt.Errorf("Instance refresh checkpoint delay seconds must be between 0 and 172800, got %v. Ignoring CR",
+				v)
+	}
+	return int64(v), nil
+
+}
+
+func InstanceRefreshCooldownSeconds(getter AnnotationsGetter, defaultCooldownSeconds int64) (int64, error) {
+	value, ok := getter.GetAnnotations()[InstanceRefreshCooldownSecondsAnnotation]
+	if !ok {
+		return defaultCooldownSeconds, nil
+	}
+	v, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return defaultCooldownSeconds, err
+	}
+	if v < 0 {
+		return defaultCooldownSeconds,
+			fmt.Errorf("Instance refresh cooldown seconds must not be negative, got %v. Ignoring CR",
+				v)
+	}
+	return v, nil
+}
+
+func InstanceRefreshForce(getter AnnotationsGetter) (bool, error) {
+	value, ok := getter.GetAnnotations()[InstanceRefreshForceAnnotation]
+	if !ok {
+		return false, nil
+	}
+	v, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, err
+	}
+	return v, nil
+}
+
+func InstanceRefreshMaxUnavailable(getter AnnotationsGetter) (int64, error) {
+	value, ok := getter.GetAnnotations()[InstanceRefreshMaxUnavailableAnnotation]
+	if !ok {
+		return 0, nil
+	}
+	v, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+	if v > 100 || v < 1 {
+		return 0,
+			fmt.Errorf("Maximum unavailable nodes must be between 1 and 100, got %v. Ignoring CR",
+				v)
+	}
+	return int64(v), nil
+}
+
+func InstanceRefreshRoller(getter AnnotationsGetter) (v1alpha1.Roller, error) {
+	value, ok := getter.GetAnnotations()[InstanceRefreshRollerAnnotation]
+	if !ok {
+		return "", nil
+	}
+	switch v := v1alpha1.Roller(value); v {
+	case v1alpha1.InstanceRefreshRoller, v1alpha1.EKSNodegroupRoller, v1alpha1.SurgeRoller:
+		return v, nil
+	}
+	return "",
+		fmt.Errorf("Instance refresh roller must be %s, %s or %s, got %s. Ignoring CR",
+			v1alpha1.InstanceRefreshRoller, v1alpha1.EKSNodegroupRoller, v1alpha1.SurgeRoller, value)
+}
+
+func InstanceRefreshSurge(getter AnnotationsGetter) (int64, error) {
+	value, ok := getter.GetAnnotations()[InstanceRefreshSurgeAnnotation]
+	if !ok {
+		return DefaultSurge, nil
+	}
+	v, err := strconv.Atoi(value)
+	if err != nil {
+		return DefaultSurge, err
+	}
+	if v < 1 {
+		return DefaultSurge,
+			fmt.Errorf("Instance refresh surge must be 1 or higher, got %v. Ignoring CR",
+				v)
+	}
+	return int64(v), nil
+}
+
+func InstanceRefreshDrain(getter AnnotationsGetter, defaultDrain bool) (bool, error) {
+	value, ok := getter.GetAnnotations()[InstanceRefreshDrainAnnotation]
+	if !ok {
+		return defaultDrain, nil
+	}
+	v, err := strconv.ParseBool(value)
+	if err != nil {
+		return defaultDrain, err
+	}
+	return v, nil
+}
+
+func InstanceRefreshDrainTimeoutSeconds(getter AnnotationsGetter) (int64, error) {
+	value, ok := getter.GetAnnotations()[InstanceRefreshDrainTimeoutSecondsAnnotation]
+	if !ok {
+		return DefaultDrainTimeoutSeconds, nil
+	}
+	v, err := strconv.Atoi(value)
+	if err != nil {
+		return DefaultDrainTimeoutSeconds, err
+	}
+	if v > 7200 || v < 30 {
+		return DefaultDrainTimeoutSeconds,
+			fmt.Errorf("Instance refresh drain timeout seconds must be between 30 and 7200, got %v. Ignoring CR",
+				v)
+	}
+	return int64(v), nil
+}
+
+func InstanceRefreshCheckPDBs(getter AnnotationsGetter, defaultCheckPDBs bool) (bool, error) {
+	value, ok := getter.GetAnnotations()[InstanceRefreshCheckPDBsAnnotation]
+	if !ok {
+		return defaultCheckPDBs, nil
+	}
+	v, err := strconv.ParseBool(value)
+	if err != nil {
+		return defaultCheckPDBs, err
+	}
+	return v, nil
+}
+
+func InstanceRefreshCheckPDBsTimeoutSeconds(getter AnnotationsGetter) (int64, error) {
+	value, ok := getter.GetAnnotations()[InstanceRefreshCheckPDBsTimeoutSecondsAnnotation]
+	if !ok {
+		return DefaultCheckPDBsTimeoutSeconds, nil
+	}
+	v, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return DefaultCheckPDBsTimeoutSeconds, err
+	}
+	if v < 0 {
+		return DefaultCheckPDBsTimeoutSeconds,
+			fmt.Errorf("Instance refresh PodDisruptionBudget check timeout seconds must not be negative, got %v. Ignoring CR",
+				v)
+	}
+	return v, nil
+}
+
+func InstanceRefreshHealthCheck(getter AnnotationsGetter, defaultHealthCheck bool) (bool, error) {
+	value, ok := getter.GetAnnotations()[InstanceRefreshHealthCheckAnnotation]
+	if !ok {
+		return defaultHealthCheck, nil
+	}
+	v, err := strconv.ParseBool(value)
+	if err != nil {
+		return defaultHealthCheck, err
+	}
+	return v, nil
+}
+
+func InstanceRefreshHealthCheckTimeoutSeconds(getter AnnotationsGetter) (int64, error) {
+	value, ok := getter.GetAnnotations()[InstanceRefreshHealthCheckTimeoutSecondsAnnotation]
+	if !ok {
+		return DefaultHealthCheckTimeoutSeconds, nil
+	}
+	v, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return DefaultHealthCheckTimeoutSeconds, err
+	}
+	if v < 0 {
+		return DefaultHealthCheckTimeoutSeconds,
+			fmt.Errorf("Instance refresh health check timeout seconds must not be negative, got %v. Ignoring CR",
+				v)
+	}
+	return v, nil
+}
+
+func InstanceRefreshHealthQuery(getter AnnotationsGetter) string {
+	return strings.TrimSpace(getter.GetAnnotations()[InstanceRefreshHealthQueryAnnotation])
+}
+
+func InstanceRefreshSchedule(getter AnnotationsGetter) (cron.Schedule, error) {
+	value := strings.TrimSpace(getter.GetAnnotations()[InstanceRefreshScheduleAnnotation])
+	if value == "" {
+		return nil, nil
+	}
+	schedule, err := cron.ParseStandard(value)
+	if err != nil {
+		return nil,
+			fmt.Errorf("Instance refresh schedule must be a cron expression, got %s: %s. Ignoring CR",
+				value, err)
+	}
+	return schedule, nil
+}
+
+func InstanceRefreshNextRun(getter AnnotationsGetter) (time.Time, error) {
+	value, ok := getter.GetAnnotations()[InstanceRefreshNextRunAnnotation]
+	if !ok {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// Trigger returns what requested the instance refresh of the given CR,
+// defaulting to the instance refresh annotation.
+func Trigger(getter AnnotationsGetter) string {
+	if value := getter.GetAnnotations()[InstanceRefreshTriggerAnnotation]; value != "" {
+		return value
+	}
+	return TriggerAnnotation
+}
+
+func MaintenanceWindow(getter AnnotationsGetter) string {
+	return strings.TrimSpace(getter.GetAnnotations()[MaintenanceWindowAnnotation])
+}
+
+func MaintenanceWindowPolicy(getter AnnotationsGetter) string {
+	return strings.TrimSpace(getter.GetAnnotations()[MaintenanceWindowPolicyAnnotation])
+}
+
+func InstanceRefreshRequireApproval(getter AnnotationsGetter) (bool, error) {
+	value, ok := getter.GetAnnotations()[InstanceRefreshRequireApprovalAnnotation]
+	if !ok {
+		return false, nil
+	}
+	return strconv.ParseBool(value)
+}
+
+func InstanceRefreshApprovedCheckpoint(getter AnnotationsGetter) (int64, error) {
+	value, ok := getter.GetAnnotations()[InstanceRefreshApproveCheckpointAnnotation]
+	if !ok {
+		return 0, nil
+	}
+	v, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+	if v > 100 || v < 0 {
+		return 0,
+			fmt.Errorf("Approved instance refresh checkpoint must be between 0 and 100, got %v. Ignoring CR",
+				v)
+	}
+	return int64(v), nil
+
+}
+
+func InstanceRefreshSkipMatching(getter AnnotationsGetter, defaultSkipMatching bool) (bool, error) {
+	value, ok := getter.GetAnnotations()[InstanceRefreshSkipMatchingAnnotation]
+	if !ok {
+		return defaultSkipMatching, nil
+	}
+	v, err := strconv.ParseBool(value)
+	if err != nil {
+		return defaultSkipMatching, err
+	}
+	return v, nil
+}
+
+func InstanceRefreshRollback(getter AnnotationsGetter, defaultRollback bool) (bool, error) {
+	value, ok := getter.GetAnnotations()[InstanceRefreshRollbackAnnotation]
+	if !ok {
+		return defaultRollback, nil
+	}
+	v, err := strconv.ParseBool(value)
+	if err != nil {
+		return defaultRollback, err
+	}
+	return v, nil
+}
+
+func InstanceRefreshLaunchTemplateVersion(getter AnnotationsGetter) (string, error) {
+	value, ok := getter.GetAnnotations()[InstanceRefreshLaunchTemplateVersionAnnotation]
+	if !ok {
+		return DefaultLaunchTemplateVersion, nil
+	}
+	if value == "$Latest" || value == "$Default" {
+		return value, nil
+	}
+	v, err := strconv.Atoi(value)
+	if err != nil || v < 1 {
+		return DefaultLaunchTemplateVersion,
+			fmt.Errorf("Launch template version must be a version number, $Latest or $Default, got %s. Ignoring CR",
+				value)
+	}
+	return value, nil
+}
+
 func AWSAccountDetails(ctx context.Context, client client.Client, cluster *infrastructurev1alpha3.AWSCluster) (string, string, error) {
 	// fetch ARN from the cluster to assume role for creating dependencies
 	credentialName := cluster.Spec.Provider.CredentialSecret.Name
@@ -0,0 +1,51 @@
+package key
+
+import (
+	"reflect"
+	"testing"
+
+	infrastructurev1alpha3 "github.com/giantswarm/apiextensions/v6/pkg/apis/infrastructure/v1alpha3"
+)
+
+func TestInstanceRefreshCheckpoints(t *testing.T) {
+	testCases := []struct {
+		name        string
+		value       *string
+		expected    []int64
+		expectError bool
+	}{
+		{name: "not set", value: nil, expected: nil},
+		{name: "single", value: s("100"), expected: []int64{100}},
+		{name: "multiple", value: s("20, 50,100"), expected: []int64{20, 50, 100}},
+		{name: "not a number", value: s("20,half"), expectError: true},
+		{name: "out of range", value: s("0,50"), expectError: true},
+		{name: "not ascending", value: s("50,20"), expectError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			md := &infrastructurev1alpha3.AWSMachineDeployment{}
+			if tc.value != nil {
+				md.SetAnnotations(map[string]string{InstanceRefreshCheckpointsAnnotation: *tc.value})
+			}
+
+			checkpoints, err := InstanceRefreshCheckpoints(md)
+			if tc.expectError {
+				if err == nil {
+					t.Fatalf("expected error, got checkpoints %v", checkpoints)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(checkpoints, tc.expected) {
+				t.Fatalf("expected %v, got %v", tc.expected, checkpoints)
+			}
+		})
+	}
+}
+
+func s(v string) *string {
+	return &v
+}
@@ -13,6 +13,9 @@ const (
 	labelCluster      = "cluster_id"
 	labelNamespace    = "cluster_namespace"
 	labelInstallation = "installation"
+	labelASG          = "asg"
+
+	instanceRefreshSubsystem = "instance_refresh"
 )
 
 var (
@@ -27,9 +30,32 @@ var (
 		},
 		labels,
 	)
+
+	asgLabels = append(append([]string{}, labels...), labelASG)
+
+	InstanceRefreshPercentageComplete = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricNamespace,
+			Subsystem: instanceRefreshSubsystem,
+			Name:      "percentage_complete",
+			Help:      "Percentage of the instance refresh of an ASG which is complete",
+		},
+		asgLabels,
+	)
+	InstanceRefreshInstancesRemaining = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricNamespace,
+			Subsystem: instanceRefreshSubsystem,
+			Name:      "instances_remaining",
+			Help:      "Number of instances remaining to update by the instance refresh of an ASG",
+		},
+		asgLabels,
+	)
 )
 
 func init() {
 	// Register custom metrics with the global prometheus registry
 	metrics.Registry.MustRegister(Errors)
+	metrics.Registry.MustRegister(InstanceRefreshPercentageComplete)
+	metrics.Registry.MustRegister(InstanceRefreshInstancesRemaining)
 }
@@ -0,0 +1,62 @@
+// Package promql evaluates PromQL expressions which gate instance refreshes.
+package promql
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/giantswarm/microerror"
+	"github.com/prometheus/client_golang/api"
+	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// queryTimeout limits the time a single query may take.
+const queryTimeout = 30 * time.Second
+
+// Client evaluates PromQL expressions against the Prometheus HTTP API.
+type Client struct {
+	api promv1.API
+}
+
+// New returns a client of the Prometheus HTTP API at the given address, e.g.
+// http://prometheus:9090.
+func New(address string) (*Client, error) {
+	c, err := api.NewClient(api.Config{Address: address})
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	return &Client{api: promv1.NewAPI(c)}, nil
+}
+
+// Evaluate returns true if the given expression evaluates to true at the
+// current time, like an alerting rule fires. An instant vector is true if it
+// has samples and none of them is 0, so both filters like
+// `rate(errors[5m]) < 0.01` and comparisons with the bool modifier work. A
+// scalar is true if it is not 0.
+func (c *Client) Evaluate(ctx context.Context, query string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	result, _, err := c.api.Query(ctx, query, time.Now())
+	if err != nil {
+		return false, microerror.Mask(err)
+	}
+
+	switch v := result.(type) {
+	case model.Vector:
+		if len(v) == 0 {
+			return false, nil
+		}
+		for _, sample := range v {
+			if sample.Value == 0 {
+				return false, nil
+			}
+		}
+		return true, nil
+	case *model.Scalar:
+		return v.Value != 0, nil
+	}
+	return false, fmt.Errorf("Query %q returned a %s, expected an instant vector or a scalar", query, result.Type())
+}
@@ -0,0 +1,43 @@
+package promql
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEvaluate(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		expected bool
+	}{
+		{name: "matching filter", data: `{"resultType":"vector","result":[{"metric":{},"value":[1650000000,"0.5"]}]}`, expected: true},
+		{name: "empty vector", data: `{"resultType":"vector","result":[]}`, expected: false},
+		{name: "false comparison", data: `{"resultType":"vector","result":[{"metric":{"a":"1"},"value":[1650000000,"1"]},{"metric":{"a":"2"},"value":[1650000000,"0"]}]}`, expected: false},
+		{name: "true scalar", data: `{"resultType":"scalar","result":[1650000000,"1"]}`, expected: true},
+		{name: "false scalar", data: `{"resultType":"scalar","result":[1650000000,"0"]}`, expected: false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintf(w, `{"status":"success","data":%s}`, tc.data)
+			}))
+			defer server.Close()
+
+			c, err := New(server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ok, err := c.Evaluate(context.Background(), "up")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tc.expected {
+				t.Fatalf("expected %v, got %v", tc.expected, ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package refresh
+
+import (
+	"errors"
+
+	"github.com/giantswarm/microerror"
+)
+
+var transientError = &microerror.Error{
+	Kind: "transientError",
+}
+
+// IsTransient asserts transientError. It is returned for failures which are
+// expected to go away, e.g. when the workload cluster is not reachable, so
+// the refresh should be retried rather than failed.
+func IsTransient(err error) bool {
+	return errors.Is(err, transientError)
+}
+
+var notCancellableError = &microerror.Error{
+	Kind: "notCancellableError",
+}
+
+// IsNotCancellable asserts notCancellableError. Rollers return it from Cancel
+// if their rolls can not be cancelled, e.g. updates of EKS node groups.
+func IsNotCancellable(err error) bool {
+	return errors.Is(err, notCancellableError)
+}
@@ -0,0 +1,105 @@
+package refresh
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/giantswarm/aws-rolling-node-operator/pkg/workload"
+)
+
+// verify gates the next ASGs on the health of the workload cluster once the
+// instances of the given ASG got replaced. The ASG is verified until all of
+// its nodes are Ready and the kube-system DaemonSets are rolled out, and
+// counts as unhealthy if that does not happen within the health check
+// timeout, which stops the instance refresh. Clusters without a kubeconfig to
+// reach them with are not verified.
+func (s *InstanceRefreshService) verify(ctx context.Context, asgState *ASGState, preferences Preferences) error {
+	if asgState.Status == autoscaling.InstanceRefreshStatusSuccessful && preferences.HealthCheck && asgState.VerifyingSince == nil {
+		now := metav1.Now()
+		asgState.Status = ASGStatusVerifying
+		asgState.VerifyingSince = &now
+	}
+	if asgState.Status != ASGStatusVerifying {
+		return nil
+	}
+
+	reason, err := s.unhealthy(ctx, asgState)
+	if err != nil {
+		return err
+	}
+	if reason == "" {
+		asgState.Status = autoscaling.InstanceRefreshStatusSuccessful
+		asgState.StatusReason = ""
+		s.Scope.Logger.Info(fmt.Sprintf("Workload cluster is healthy after refreshing ASG %s", asgState.Name))
+		return nil
+	}
+
+	timeout := time.Duration(preferences.HealthCheckTimeoutSeconds) * time.Second
+	if time.Since(asgState.VerifyingSince.Time) < timeout {
+		asgState.StatusReason = reason
+		return nil
+	}
+	asgState.Status = ASGStatusUnhealthy
+	asgState.StatusReason = reason
+	message := fmt.Sprintf("Workload cluster is not healthy %s after refreshing ASG %s: %s Stopping the instance refresh.",
+		timeout, asgState.Name, reason)
+	s.Scope.Logger.Info(message)
+	s.event(v1.EventTypeWarning, "InstanceRefreshUnhealthy", message)
+	return nil
+}
+
+// unhealthy describes why the workload cluster is not healthy after the given
+// ASG got refreshed, or returns an empty string if it is healthy. Failing to
+// reach the workload cluster counts as unhealthy.
+func (s *InstanceRefreshService) unhealthy(ctx context.Context, asgState *ASGState) (string, error) {
+	k8sClient, err := s.workloadCluster(ctx)
+	if IsTransient(err) {
+		return err.Error(), nil
+	} else if err != nil {
+		s.Scope.Logger.Info(fmt.Sprintf("Skipping the health check of ASG %s: %s", asgState.Name, err))
+		return "", nil
+	}
+
+	output, err := s.ASG.Client.DescribeAutoScalingGroups(&autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: []*string{aws.String(asgState.Name)},
+	})
+	if err != nil {
+		s.Scope.Logger.Error(err, "failed to describe autoscaling group")
+		return "", err
+	}
+	nodes, err := workload.NodesByInstanceID(ctx, k8sClient)
+	if err != nil {
+		return fmt.Sprintf("Failed to list nodes: %s.", err), nil
+	}
+	var notReady []string
+	if len(output.AutoScalingGroups) > 0 {
+		for _, instance := range output.AutoScalingGroups[0].Instances {
+			if aws.StringValue(instance.LifecycleState) != autoscaling.LifecycleStateInService {
+				continue
+			}
+			id := aws.StringValue(instance.InstanceId)
+			if node, ok := nodes[id]; !ok || !workload.Ready(node) {
+				notReady = append(notReady, id)
+			}
+		}
+	}
+	if len(notReady) > 0 {
+		return fmt.Sprintf("Nodes of instances %s are not Ready.", strings.Join(notReady, ", ")), nil
+	}
+
+	daemonSets, err := workload.DaemonSetsNotRolledOut(ctx, k8sClient, metav1.NamespaceSystem)
+	if err != nil {
+		return fmt.Sprintf("Failed to list DaemonSets: %s.", err), nil
+	}
+	if len(daemonSets) > 0 {
+		return fmt.Sprintf("DaemonSets %s in namespace %s are not rolled out.", strings.Join(daemonSets, ", "), metav1.NamespaceSystem), nil
+	}
+	return "", nil
+}
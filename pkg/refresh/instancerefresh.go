@@ -0,0 +1,259 @@
+package refresh
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	v1 "k8s.io/api/core/v1"
+)
+
+// instanceRefreshRoller rolls ASGs with an instance refresh. AWS replaces
+// the instances and pauses at checkpoints itself.
+type instanceRefreshRoller struct {
+	s *InstanceRefreshService
+}
+
+// Start starts the instance refresh for the given ASG and records its ID. The
+// ASG is marked as skipped if there is nothing to refresh or it got refreshed
+// within the cooldown. The instance refresh is delayed while
+// PodDisruptionBudgets block draining the nodes of the ASG, and the ASG fails
+// if its nodes are to be drained but the workload cluster has no kubeconfig.
+func (r *instanceRefreshRoller) Start(ctx context.Context, asgState *ASGState, preferences Preferences, ignoreCooldown bool) error {
+	asgOutput, err := r.s.ASG.Client.DescribeAutoScalingGroups(&autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: []*string{aws.String(asgState.Name)},
+	})
+	if err != nil {
+		r.s.Scope.Logger.Error(err, "failed to describe autoscaling group")
+		return err
+	}
+	if len(asgOutput.AutoScalingGroups) == 0 {
+		r.s.skip(asgState, fmt.Sprintf("ASG %s does not exist anymore, skipping...", asgState.Name))
+		return nil
+	}
+	asg := asgOutput.AutoScalingGroups[0]
+
+	output, err := r.s.ASG.Client.DescribeInstanceRefreshes(&autoscaling.DescribeInstanceRefreshesInput{
+		AutoScalingGroupName: asg.AutoScalingGroupName,
+	})
+	if err != nil {
+		r.s.Scope.Logger.Error(err, "failed to describe instance refreshes")
+		return err
+	}
+	if !ignoreCooldown && !preferences.Force {
+		cooldown := time.Duration(preferences.CooldownSeconds) * time.Second
+		if ended := lastEndTime(output.InstanceRefreshes); ended != nil && time.Since(*ended) < cooldown {
+			r.s.skip(asgState, fmt.Sprintf("ASG %s already refreshed within the cooldown of %d seconds, skipping... Force the instance refresh to refresh it anyway.",
+				*asg.AutoScalingGroupName, preferences.CooldownSeconds))
+			return nil
+		}
+	}
+
+	if len(asg.Instances) == 0 {
+		r.s.skip(asgState, fmt.Sprintf("ASG %s has no instances, skipping...", *asg.AutoScalingGroupName))
+		return nil
+	}
+
+	desired, err := desiredConfiguration(asg, preferences.LaunchTemplateVersion)
+	if err != nil {
+		r.s.skip(asgState, fmt.Sprintf("%s, skipping...", err))
+		return nil
+	}
+
+	if preferences.CheckPDBs {
+		blocked, err := r.s.disruptionsBlocked(ctx, asgState, asg, preferences)
+		if err != nil || blocked {
+			return err
+		}
+	}
+
+	asgState.Instances = int64(len(asg.Instances))
+	asgState.SkipMatching = preferences.SkipMatching
+	if asgState.PreviousLaunchTemplateVersion == "" {
+		asgState.PreviousLaunchTemplateVersion = launchTemplateVersionInPlace(asg)
+	}
+
+	strategy := preferences.Strategy
+	if strategy == "" {
+		strategy = autoscaling.RefreshStrategyRolling
+	}
+	refreshInput := &autoscaling.StartInstanceRefreshInput{
+		AutoScalingGroupName: asg.AutoScalingGroupName,
+		DesiredConfiguration: desired,
+		Preferences: &autoscaling.RefreshPreferences{
+			CheckpointDelay:       nil,
+			CheckpointPercentages: aws.Int64Slice(preferences.CheckpointPercentages),
+			InstanceWarmup:        aws.Int64(preferences.InstanceWarmupSeconds),
+			MinHealthyPercentage:  aws.Int64(preferences.MinHealthyPercentage),
+			SkipMatching:          nil,
+		},
+		Strategy: aws.String(strategy),
+	}
+	if preferences.SkipMatching {
+		refreshInput.Preferences.SkipMatching = aws.Bool(true)
+	}
+	if len(preferences.CheckpointPercentages) > 0 {
+		refreshInput.Preferences.CheckpointDelay = aws.Int64(preferences.CheckpointDelaySeconds)
+	}
+	if preferences.Drain {
+		ok, err := r.s.requireWorkloadCluster(ctx, asgState)
+		if err != nil || !ok {
+			return err
+		}
+		err = r.s.putDrainHook(asgState, preferences)
+		if err != nil {
+			return err
+		}
+	}
+	refreshOutput, err := r.s.ASG.Client.StartInstanceRefresh(refreshInput)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == autoscaling.ErrCodeInstanceRefreshInProgressFault {
+		// The refresh may have been started by us before the operator
+		// restarted without its ID being persisted. Adopt it.
+		r.s.Scope.Logger.Info(fmt.Sprintf("An instance refresh is already in progress for ASG %s.",
+			*asg.AutoScalingGroupName))
+		for _, ir := range output.InstanceRefreshes {
+			if ir.EndTime != nil {
+				continue
+			}
+			if *ir.Status == autoscaling.InstanceRefreshStatusCancelling {
+				r.s.Scope.Logger.Info(fmt.Sprintf("Waiting for the previous instance refresh of ASG %s to be cancelled.",
+					*asg.AutoScalingGroupName))
+				return nil
+			}
+			asgState.InstanceRefreshID = *ir.InstanceRefreshId
+			asgState.Status = *ir.Status
+			if ir.Preferences != nil {
+				asgState.SkipMatching = aws.BoolValue(ir.Preferences.SkipMatching)
+			}
+			return nil
+		}
+		return err
+	} else if err != nil {
+		r.s.Scope.Logger.Error(err, "failed to start instance refresh")
+		return err
+	}
+
+	r.s.Scope.Logger.Info(fmt.Sprintf("Started refreshing instances in ASG %s", *asg.AutoScalingGroupName))
+	asgState.InstanceRefreshID = *refreshOutput.InstanceRefreshId
+	asgState.Status = autoscaling.InstanceRefreshStatusPending
+	return nil
+}
+
+// lastEndTime returns the time the most recently ended instance refresh ended,
+// or nil if none ended yet. The instance refresh in progress, if any, has no
+// end time and is ignored.
+func lastEndTime(instanceRefreshes []*autoscaling.InstanceRefresh) *time.Time {
+	var last *time.Time
+	for _, ir := range instanceRefreshes {
+		if ir.EndTime != nil && (last == nil || ir.EndTime.After(*last)) {
+			last = ir.EndTime
+		}
+	}
+	return last
+}
+
+// Progress updates the status of the instance refresh of the given ASG.
+func (r *instanceRefreshRoller) Progress(ctx context.Context, asgState *ASGState) error {
+	output, err := r.s.ASG.Client.DescribeInstanceRefreshes(&autoscaling.DescribeInstanceRefreshesInput{
+		AutoScalingGroupName: aws.String(asgState.Name),
+		InstanceRefreshIds:   []*string{aws.String(asgState.InstanceRefreshID)},
+	})
+	if err != nil {
+		r.s.Scope.Logger.Error(err, "failed to describe instance refreshes")
+		return err
+	}
+	if len(output.InstanceRefreshes) == 0 {
+		return fmt.Errorf("Instance refresh %s for ASG %s not found", asgState.InstanceRefreshID, asgState.Name)
+	}
+	instanceRefresh := output.InstanceRefreshes[0]
+	asgState.Status = *instanceRefresh.Status
+	asgState.PercentageComplete = fromResumed(aws.Int64Value(instanceRefresh.PercentageComplete), asgState.ResumedAt)
+	asgState.StatusReason = aws.StringValue(instanceRefresh.StatusReason)
+	asgState.InstancesRemaining = aws.Int64Value(instanceRefresh.InstancesToUpdate)
+
+	// The number of instances to update is highest right after the instance
+	// refresh started. It tells how many instances did not match the desired
+	// configuration.
+	if aws.Int64Value(instanceRefresh.InstancesToUpdate) > asgState.InstancesToUpdate {
+		asgState.InstancesToUpdate = *instanceRefresh.InstancesToUpdate
+	}
+
+	r.checkpoint(asgState, instanceRefresh)
+
+	err = r.s.drainTerminating(ctx, asgState)
+	if err != nil {
+		return err
+	}
+
+	switch asgState.Status {
+	case autoscaling.InstanceRefreshStatusSuccessful:
+		r.s.Scope.Logger.Info(fmt.Sprintf("Successfully refreshed all instances in ASG %s", asgState.Name))
+	case autoscaling.InstanceRefreshStatusCancelling:
+		r.s.Scope.Logger.Info(fmt.Sprintf("Cancelling refreshing instances in ASG %s", asgState.Name))
+	case autoscaling.InstanceRefreshStatusCancelled:
+		r.s.Scope.Logger.Info(fmt.Sprintf("Cancelled refreshing instances in ASG %s", asgState.Name))
+	default:
+		r.s.Scope.Logger.Info(fmt.Sprintf("Refreshing instances in ASG %s, Status: %s, %d%% complete, %d instances remaining. %s",
+			asgState.Name, asgState.Status, asgState.PercentageComplete, asgState.InstancesRemaining, asgState.StatusReason))
+	}
+	return nil
+}
+
+// checkpoint records the highest checkpoint the instance refresh of the given
+// ASG has reached and emits an event whenever a new one got reached. AWS
+// itself pauses the instance refresh at every checkpoint for the configured
+// checkpoint delay. Checkpoints of resumed instance refreshes are recorded
+// relative to all instances of the ASG, like they got requested.
+func (r *instanceRefreshRoller) checkpoint(asgState *ASGState, instanceRefresh *autoscaling.InstanceRefresh) {
+	if instanceRefresh.Preferences == nil || instanceRefresh.PercentageComplete == nil {
+		return
+	}
+
+	reached := asgState.Checkpoint
+	for _, c := range instanceRefresh.Preferences.CheckpointPercentages {
+		if c == nil || *c > *instanceRefresh.PercentageComplete {
+			continue
+		}
+		if checkpoint := fromResumed(*c, asgState.ResumedAt); checkpoint > reached {
+			reached = checkpoint
+		}
+	}
+	if reached == asgState.Checkpoint {
+		return
+	}
+	asgState.Checkpoint = reached
+
+	message := fmt.Sprintf("ASG %s reached checkpoint %d%%.", asgState.Name, reached)
+	if reached < 100 && instanceRefresh.Preferences.CheckpointDelay != nil {
+		message = fmt.Sprintf("ASG %s reached checkpoint %d%%, waiting %d seconds before continuing.",
+			asgState.Name, reached, *instanceRefresh.Preferences.CheckpointDelay)
+	}
+	r.s.Scope.Logger.Info(message)
+	r.s.event(v1.EventTypeNormal, "InstanceRefreshCheckpointReached", message)
+}
+
+// Cancel cancels the instance refresh of the given ASG and removes its
+// lifecycle hook. It is not an error if there is no instance refresh in
+// progress anymore.
+func (r *instanceRefreshRoller) Cancel(ctx context.Context, asgState *ASGState) error {
+	_, err := r.s.ASG.Client.CancelInstanceRefresh(&autoscaling.CancelInstanceRefreshInput{
+		AutoScalingGroupName: aws.String(asgState.Name),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == autoscaling.ErrCodeActiveInstanceRefreshNotFoundFault {
+		r.s.Scope.Logger.Info(fmt.Sprintf("No active instance refresh found for ASG %s", asgState.Name))
+	} else if err != nil {
+		r.s.Scope.Logger.Error(err, "failed to cancel instance refresh")
+		return err
+	}
+	return r.s.deleteDrainHook(asgState)
+}
+
+// Restore removes the lifecycle hook of the given failed ASG, so instances
+// terminated after the instance refresh are not held until its heartbeat
+// timeout.
+func (r *instanceRefreshRoller) Restore(ctx context.Context, asgState *ASGState) error {
+	return r.s.deleteDrainHook(asgState)
+}
@@ -0,0 +1,159 @@
+package refresh
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	"github.com/giantswarm/aws-rolling-node-operator/pkg/key"
+)
+
+// launchTemplate returns the launch template the given ASG launches its
+// instances from, either directly or through its mixed instances policy.
+func launchTemplate(asg *autoscaling.Group) *autoscaling.LaunchTemplateSpecification {
+	if asg.LaunchTemplate != nil {
+		return asg.LaunchTemplate
+	}
+	if asg.MixedInstancesPolicy != nil && asg.MixedInstancesPolicy.LaunchTemplate != nil {
+		return asg.MixedInstancesPolicy.LaunchTemplate.LaunchTemplateSpecification
+	}
+	return nil
+}
+
+// desiredConfiguration builds the configuration the instances of the given ASG
+// get replaced with. The launch template is the one of the ASG, only its
+// version is set to the given one. ASGs with a mixed instances policy keep
+// their overrides and instances distribution, so spot settings survive the
+// instance refresh.
+func desiredConfiguration(asg *autoscaling.Group, version string) (*autoscaling.DesiredConfiguration, error) {
+	current := launchTemplate(asg)
+	if current == nil {
+		return nil, fmt.Errorf("ASG %s does not use a launch template", *asg.AutoScalingGroupName)
+	}
+
+	if asg.MixedInstancesPolicy != nil {
+		return &autoscaling.DesiredConfiguration{
+			MixedInstancesPolicy: &autoscaling.MixedInstancesPolicy{
+				InstancesDistribution: asg.MixedInstancesPolicy.InstancesDistribution,
+				LaunchTemplate: &autoscaling.LaunchTemplate{
+					LaunchTemplateSpecification: launchTemplateSpecification(current, version),
+					Overrides:                   asg.MixedInstancesPolicy.LaunchTemplate.Overrides,
+				},
+			},
+		}, nil
+	}
+
+	return &autoscaling.DesiredConfiguration{
+		LaunchTemplate: launchTemplateSpecification(current, version),
+	}, nil
+}
+
+// launchTemplateSpecification references the given launch template in the
+// given version. AWS accepts either the ID or the name of the launch
+// template, but not both.
+func launchTemplateSpecification(current *autoscaling.LaunchTemplateSpecification, version string) *autoscaling.LaunchTemplateSpecification {
+	if version == "" {
+		version = key.DefaultLaunchTemplateVersion
+	}
+	spec := &autoscaling.LaunchTemplateSpecification{
+		Version: aws.String(version),
+	}
+	if current.LaunchTemplateId != nil {
+		spec.LaunchTemplateId = current.LaunchTemplateId
+	} else {
+		spec.LaunchTemplateName = current.LaunchTemplateName
+	}
+	return spec
+}
+
+// launchTemplateVersionInPlace returns the launch template version the
+// instances of the given ASG currently run. AWS reports the version each
+// instance got launched with, so the most common one is used. It falls back
+// to the version configured on the ASG if that is a version number, and
+// returns an empty string if the version can not be determined.
+func launchTemplateVersionInPlace(asg *autoscaling.Group) string {
+	counts := map[string]int{}
+	var inPlace string
+	for _, instance := range asg.Instances {
+		if instance.LaunchTemplate == nil || !isVersionNumber(instance.LaunchTemplate.Version) {
+			continue
+		}
+		version := *instance.LaunchTemplate.Version
+		counts[version]++
+		if counts[version] > counts[inPlace] {
+			inPlace = version
+		}
+	}
+	if inPlace != "" {
+		return inPlace
+	}
+
+	current := launchTemplate(asg)
+	if current != nil && isVersionNumber(current.Version) {
+		return *current.Version
+	}
+	return ""
+}
+
+// configuredLaunchTemplateVersion returns the launch template version the
+// given ASG is configured to launch instances of. Launch templates referenced
+// without a version launch their default version.
+func configuredLaunchTemplateVersion(asg *autoscaling.Group) string {
+	current := launchTemplate(asg)
+	if current == nil {
+		return ""
+	}
+	if current.Version == nil {
+		return "$Default"
+	}
+	return *current.Version
+}
+
+// launchTemplateVersionNumber resolves $Latest and $Default to the version
+// number of the launch template of the given ASG. Version numbers are
+// returned as they are.
+func (s *InstanceRefreshService) launchTemplateVersionNumber(asg *autoscaling.Group, version string) (string, error) {
+	if version == "" {
+		version = key.DefaultLaunchTemplateVersion
+	}
+	if isVersionNumber(&version) {
+		return version, nil
+	}
+
+	current := launchTemplate(asg)
+	if current == nil {
+		return "", fmt.Errorf("ASG %s does not use a launch template", *asg.AutoScalingGroupName)
+	}
+	input := &ec2.DescribeLaunchTemplatesInput{}
+	name := aws.StringValue(current.LaunchTemplateId)
+	if current.LaunchTemplateId != nil {
+		input.LaunchTemplateIds = []*string{current.LaunchTemplateId}
+	} else {
+		name = aws.StringValue(current.LaunchTemplateName)
+		input.LaunchTemplateNames = []*string{current.LaunchTemplateName}
+	}
+	output, err := s.ASG.EC2.DescribeLaunchTemplates(input)
+	if err != nil {
+		s.Scope.Logger.Error(err, "failed to describe launch template")
+		return "", err
+	}
+	if len(output.LaunchTemplates) == 0 {
+		return "", fmt.Errorf("Launch template %s not found", name)
+	}
+	number := output.LaunchTemplates[0].LatestVersionNumber
+	if version == "$Default" {
+		number = output.LaunchTemplates[0].DefaultVersionNumber
+	}
+	return strconv.FormatInt(aws.Int64Value(number), 10), nil
+}
+
+func isVersionNumber(version *string) bool {
+	if version == nil {
+		return false
+	}
+	_, err := strconv.ParseInt(*version, 10, 64)
+	return err == nil
+}
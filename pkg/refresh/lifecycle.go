@@ -0,0 +1,222 @@
+package refresh
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/giantswarm/microerror"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/giantswarm/aws-rolling-node-operator/pkg/workload"
+)
+
+const (
+	// DrainLifecycleHookName is the name of the lifecycle hook holding the
+	// instances an instance refresh terminates until their nodes got
+	// drained.
+	DrainLifecycleHookName = "aws-rolling-node-operator-drain"
+
+	lifecycleStateTerminatingWait = "Terminating:Wait"
+	lifecycleActionContinue       = "CONTINUE"
+)
+
+// putDrainHook registers the lifecycle hook which holds terminating instances
+// of the given ASG for at most the drain timeout. AWS continues terminating
+// the instances once the timeout passed, even if the operator is gone.
+func (s *InstanceRefreshService) putDrainHook(asgState *ASGState, preferences Preferences) error {
+	_, err := s.ASG.Client.PutLifecycleHook(&autoscaling.PutLifecycleHookInput{
+		AutoScalingGroupName: aws.String(asgState.Name),
+		LifecycleHookName:    aws.String(DrainLifecycleHookName),
+		LifecycleTransition:  aws.String("autoscaling:EC2_INSTANCE_TERMINATING"),
+		DefaultResult:        aws.String(lifecycleActionContinue),
+		HeartbeatTimeout:     aws.Int64(preferences.DrainTimeoutSeconds),
+	})
+	if err != nil {
+		s.Scope.Logger.Error(err, "failed to put lifecycle hook")
+		return err
+	}
+	return nil
+}
+
+// deleteDrainHook removes the lifecycle hook from the given ASG, so instances
+// terminated outside of instance refreshes are not held. AWS continues
+// terminating the instances it still holds.
+func (s *InstanceRefreshService) deleteDrainHook(asgState *ASGState) error {
+	hook, err := s.drainHook(asgState)
+	if err != nil || hook == nil {
+		return err
+	}
+	_, err = s.ASG.Client.DeleteLifecycleHook(&autoscaling.DeleteLifecycleHookInput{
+		AutoScalingGroupName: aws.String(asgState.Name),
+		LifecycleHookName:    aws.String(DrainLifecycleHookName),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "ValidationError" {
+		// The lifecycle hook or the ASG does not exist anymore.
+		return nil
+	} else if err != nil {
+		s.Scope.Logger.Error(err, "failed to delete lifecycle hook")
+		return err
+	}
+	asgState.DrainingSince = nil
+	return nil
+}
+
+// drainHook returns the lifecycle hook of the given ASG or nil if draining is
+// not enabled for it.
+func (s *InstanceRefreshService) drainHook(asgState *ASGState) (*autoscaling.LifecycleHook, error) {
+	output, err := s.ASG.Client.DescribeLifecycleHooks(&autoscaling.DescribeLifecycleHooksInput{
+		AutoScalingGroupName: aws.String(asgState.Name),
+		LifecycleHookNames:   []*string{aws.String(DrainLifecycleHookName)},
+	})
+	if err != nil {
+		s.Scope.Logger.Error(err, "failed to describe lifecycle hooks")
+		return nil, err
+	}
+	if len(output.LifecycleHooks) == 0 {
+		return nil, nil
+	}
+	return output.LifecycleHooks[0], nil
+}
+
+// drainTerminating cordons and drains the nodes of the instances of the given
+// ASG which are held by the lifecycle hook. The termination continues once no
+// pods are left on a node, its node is gone or the heartbeat timeout of the
+// lifecycle hook passed, so a PodDisruptionBudget blocking the drain does not
+// block the instance refresh. It does nothing if the ASG has no lifecycle
+// hook, and removes the lifecycle hook once the instance refresh ended. The
+// drain start times are only replaced after a complete pass, so the drain
+// timeout does not restart on errors.
+func (s *InstanceRefreshService) drainTerminating(ctx context.Context, asgState *ASGState) error {
+	hook, err := s.drainHook(asgState)
+	if err != nil || hook == nil {
+		return err
+	}
+	switch asgState.Status {
+	case autoscaling.InstanceRefreshStatusSuccessful, autoscaling.InstanceRefreshStatusFailed, autoscaling.InstanceRefreshStatusCancelled:
+		return s.deleteDrainHook(asgState)
+	}
+
+	output, err := s.ASG.Client.DescribeAutoScalingGroups(&autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: []*string{aws.String(asgState.Name)},
+	})
+	if err != nil {
+		s.Scope.Logger.Error(err, "failed to describe autoscaling group")
+		return err
+	}
+	draining := map[string]metav1.Time{}
+	if len(output.AutoScalingGroups) > 0 {
+		for _, instance := range output.AutoScalingGroups[0].Instances {
+			if aws.StringValue(instance.LifecycleState) != lifecycleStateTerminatingWait {
+				continue
+			}
+			id := aws.StringValue(instance.InstanceId)
+			since, ok := asgState.DrainingSince[id]
+			if !ok {
+				since = metav1.Now()
+			}
+			draining[id] = since
+		}
+	}
+	if len(draining) == 0 {
+		asgState.DrainingSince = nil
+		return nil
+	}
+
+	k8sClient, err := s.workloadCluster(ctx)
+	if IsTransient(err) {
+		return err
+	} else if err != nil {
+		// The kubeconfig got removed after the instance refresh started. AWS
+		// terminates the held instances once the heartbeat timeout passed.
+		s.Scope.Logger.Info(fmt.Sprintf("Skipping draining instances of ASG %s: %s", asgState.Name, err))
+		asgState.DrainingSince = draining
+		return nil
+	}
+	nodes, err := workload.NodesByInstanceID(ctx, k8sClient)
+	if err != nil {
+		return microerror.Maskf(transientError, "failed to list nodes of ASG %s: %s", asgState.Name, err)
+	}
+
+	timeout := time.Duration(aws.Int64Value(hook.HeartbeatTimeout)) * time.Second
+	pending := map[string]metav1.Time{}
+	var ids, blocked []string
+	for id := range draining {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		since := draining[id]
+		if node, ok := nodes[id]; ok {
+			err = workload.Cordon(ctx, k8sClient, node)
+			if err != nil {
+				return microerror.Maskf(transientError, "failed to cordon node %s: %s", node.Name, err)
+			}
+			status, err := workload.Drain(ctx, k8sClient, node.Name)
+			if err != nil {
+				return microerror.Maskf(transientError, "failed to drain node %s: %s", node.Name, err)
+			}
+			if status.Remaining > 0 && time.Since(since.Time) < timeout {
+				blocked = append(blocked, status.Blocked...)
+				pending[id] = since
+				continue
+			}
+			if status.Remaining > 0 {
+				message := fmt.Sprintf("Draining node %s of instance %s in ASG %s timed out after %s with %d pods left, terminating it anyway.",
+					node.Name, id, asgState.Name, timeout, status.Remaining)
+				s.Scope.Logger.Info(message)
+				s.event(v1.EventTypeWarning, "DrainTimedOut", message)
+			}
+		}
+
+		err = s.completeLifecycleAction(asgState, id)
+		if err != nil {
+			return err
+		}
+		s.Scope.Logger.Info(fmt.Sprintf("Drained instance %s of ASG %s", id, asgState.Name))
+	}
+
+	asgState.DrainingSince = nil
+	if len(pending) > 0 {
+		asgState.DrainingSince = pending
+		var names []string
+		for id := range pending {
+			names = append(names, id)
+		}
+		sort.Strings(names)
+		reason := fmt.Sprintf("Draining instances %s.", strings.Join(names, ", "))
+		if len(blocked) > 0 {
+			reason = fmt.Sprintf("Eviction of pods %s is blocked by PodDisruptionBudgets.", strings.Join(blocked, ", "))
+		}
+		if asgState.StatusReason != "" {
+			reason = fmt.Sprintf("%s %s", asgState.StatusReason, reason)
+		}
+		asgState.StatusReason = reason
+	}
+	return nil
+}
+
+// completeLifecycleAction lets AWS continue terminating the given instance.
+// It is not an error if AWS already continued, e.g. after the heartbeat
+// timeout.
+func (s *InstanceRefreshService) completeLifecycleAction(asgState *ASGState, instanceID string) error {
+	_, err := s.ASG.Client.CompleteLifecycleAction(&autoscaling.CompleteLifecycleActionInput{
+		AutoScalingGroupName:  aws.String(asgState.Name),
+		LifecycleHookName:     aws.String(DrainLifecycleHookName),
+		InstanceId:            aws.String(instanceID),
+		LifecycleActionResult: aws.String(lifecycleActionContinue),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "ValidationError" {
+		s.Scope.Logger.Info(fmt.Sprintf("No lifecycle action found for instance %s of ASG %s", instanceID, asgState.Name))
+	} else if err != nil {
+		s.Scope.Logger.Error(err, "failed to complete lifecycle action")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,343 @@
+package refresh
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/eks"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/giantswarm/microerror"
+	v1 "k8s.io/api/core/v1"
+)
+
+// releaseVersionParameters maps AMI types of node groups to the SSM
+// parameters holding their latest AMI release for a Kubernetes version.
+var releaseVersionParameters = map[string]string{
+	eks.AMITypesAl2X8664:    "/aws/service/eks/optimized-ami/%s/amazon-linux-2/recommended/release_version",
+	eks.AMITypesAl2X8664Gpu: "/aws/service/eks/optimized-ami/%s/amazon-linux-2-gpu/recommended/release_version",
+	eks.AMITypesAl2Arm64:    "/aws/service/eks/optimized-ami/%s/amazon-linux-2-arm64/recommended/release_version",
+}
+
+// nodegroupRoller rolls the ASGs of EKS managed node groups by updating the
+// node group. The ID of the update is recorded as InstanceRefreshID.
+type nodegroupRoller struct {
+	s *InstanceRefreshService
+}
+
+// Start starts the update of the EKS managed node group backing the given ASG
+// and records the ID of the update. ASGs which do not belong to a node group
+// are skipped, as are node groups which already run the desired launch
+// template version or AMI release. EKS replaces the nodes itself,
+// at most MaxUnavailable at a time. If the update config of the node group
+// does not match, it gets updated first and the version update is started by
+// a later call once the node group is active again. The cooldown does not
+// apply, as EKS keeps no instance refresh history of its ASGs.
+func (r *nodegroupRoller) Start(ctx context.Context, asgState *ASGState, preferences Preferences, ignoreCooldown bool) error {
+	if asgState.EKSNodegroupName == "" {
+		r.s.skip(asgState, fmt.Sprintf("ASG %s does not belong to an EKS managed node group, skipping...", asgState.Name))
+		return nil
+	}
+
+	asgOutput, err := r.s.ASG.Client.DescribeAutoScalingGroups(&autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: []*string{aws.String(asgState.Name)},
+	})
+	if err != nil {
+		r.s.Scope.Logger.Error(err, "failed to describe autoscaling group")
+		return err
+	}
+	if len(asgOutput.AutoScalingGroups) == 0 {
+		r.s.skip(asgState, fmt.Sprintf("ASG %s does not exist anymore, skipping...", asgState.Name))
+		return nil
+	}
+	if len(asgOutput.AutoScalingGroups[0].Instances) == 0 {
+		r.s.skip(asgState, fmt.Sprintf("ASG %s has no instances, skipping...", asgState.Name))
+		return nil
+	}
+
+	output, err := r.s.EKS.Client.DescribeNodegroup(&eks.DescribeNodegroupInput{
+		ClusterName:   aws.String(asgState.EKSClusterName),
+		NodegroupName: aws.String(asgState.EKSNodegroupName),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == eks.ErrCodeResourceNotFoundException {
+		r.s.skip(asgState, fmt.Sprintf("EKS node group %s of ASG %s does not exist anymore, skipping...", asgState.EKSNodegroupName, asgState.Name))
+		return nil
+	} else if err != nil {
+		r.s.Scope.Logger.Error(err, "failed to describe EKS node group")
+		return err
+	}
+	nodegroup := output.Nodegroup
+
+	asgState.Instances = int64(len(asgOutput.AutoScalingGroups[0].Instances))
+	if asgState.PreviousLaunchTemplateVersion == "" && nodegroup.LaunchTemplate != nil {
+		asgState.PreviousLaunchTemplateVersion = aws.StringValue(nodegroup.LaunchTemplate.Version)
+	}
+	if aws.StringValue(nodegroup.Status) == eks.NodegroupStatusUpdating {
+		return r.adoptUpdate(asgState)
+	}
+
+	updateConfig := nodegroupUpdateConfig(preferences)
+	if !matchesUpdateConfig(nodegroup.UpdateConfig, updateConfig) {
+		_, err = r.s.EKS.Client.UpdateNodegroupConfig(&eks.UpdateNodegroupConfigInput{
+			ClusterName:   nodegroup.ClusterName,
+			NodegroupName: nodegroup.NodegroupName,
+			UpdateConfig:  updateConfig,
+		})
+		if err != nil {
+			r.s.Scope.Logger.Error(err, "failed to update EKS node group config")
+			return err
+		}
+		r.s.Scope.Logger.Info(fmt.Sprintf("Updating max unavailable nodes of EKS node group %s before updating it", asgState.EKSNodegroupName))
+		return nil
+	}
+
+	// Without a launch template EKS updates the node group to the latest
+	// AMI release of its Kubernetes version.
+	versionInput := &eks.UpdateNodegroupVersionInput{
+		ClusterName:   nodegroup.ClusterName,
+		NodegroupName: nodegroup.NodegroupName,
+	}
+	if nodegroup.LaunchTemplate != nil {
+		versionInput.LaunchTemplate, err = r.launchTemplate(nodegroup.LaunchTemplate, preferences.LaunchTemplateVersion)
+		if err != nil {
+			return err
+		}
+		version := aws.StringValue(versionInput.LaunchTemplate.Version)
+		if aws.StringValue(nodegroup.LaunchTemplate.Version) == version {
+			r.s.skip(asgState, fmt.Sprintf("EKS node group %s of ASG %s already runs launch template version %s, skipping...",
+				asgState.EKSNodegroupName, asgState.Name, version))
+			return nil
+		}
+	} else {
+		release, err := r.releaseVersion(nodegroup)
+		if err != nil {
+			return err
+		}
+		if release != "" && release == aws.StringValue(nodegroup.ReleaseVersion) {
+			r.s.skip(asgState, fmt.Sprintf("EKS node group %s of ASG %s already runs AMI release %s, skipping...",
+				asgState.EKSNodegroupName, asgState.Name, release))
+			return nil
+		}
+		if release != "" {
+			versionInput.ReleaseVersion = aws.String(release)
+		}
+	}
+	versionOutput, err := r.s.EKS.Client.UpdateNodegroupVersion(versionInput)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == eks.ErrCodeResourceInUseException {
+		return r.adoptUpdate(asgState)
+	} else if ok && aerr.Code() == eks.ErrCodeInvalidParameterException {
+		message := fmt.Sprintf("EKS refused to update node group %s of ASG %s: %s",
+			asgState.EKSNodegroupName, asgState.Name, aerr.Message())
+		r.s.Scope.Logger.Info(message)
+		r.s.event(v1.EventTypeWarning, "EKSNodegroupUpdateFailed", message)
+		asgState.Status = autoscaling.InstanceRefreshStatusFailed
+		asgState.StatusReason = message
+		return nil
+	} else if err != nil {
+		r.s.Scope.Logger.Error(err, "failed to update EKS node group version")
+		return err
+	}
+
+	r.s.Scope.Logger.Info(fmt.Sprintf("Started updating EKS node group %s of ASG %s", asgState.EKSNodegroupName, asgState.Name))
+	asgState.InstanceRefreshID = aws.StringValue(versionOutput.Update.Id)
+	asgState.Status = aws.StringValue(versionOutput.Update.Status)
+	return nil
+}
+
+// adoptUpdate records the version update of the node group of the
+// given ASG which is in progress. It may have been started by us before the
+// operator restarted without its ID being persisted. Other updates are waited
+// for.
+func (r *nodegroupRoller) adoptUpdate(asgState *ASGState) error {
+	output, err := r.s.EKS.Client.ListUpdates(&eks.ListUpdatesInput{
+		Name:          aws.String(asgState.EKSClusterName),
+		NodegroupName: aws.String(asgState.EKSNodegroupName),
+	})
+	if err != nil {
+		r.s.Scope.Logger.Error(err, "failed to list EKS node group updates")
+		return err
+	}
+	for _, id := range output.UpdateIds {
+		updateOutput, err := r.s.EKS.Client.DescribeUpdate(&eks.DescribeUpdateInput{
+			Name:          aws.String(asgState.EKSClusterName),
+			NodegroupName: aws.String(asgState.EKSNodegroupName),
+			UpdateId:      id,
+		})
+		if err != nil {
+			r.s.Scope.Logger.Error(err, "failed to describe EKS node group update")
+			return err
+		}
+		update := updateOutput.Update
+		if aws.StringValue(update.Status) != eks.UpdateStatusInProgress || aws.StringValue(update.Type) != eks.UpdateTypeVersionUpdate {
+			continue
+		}
+		r.s.Scope.Logger.Info(fmt.Sprintf("An update is already in progress for EKS node group %s.", asgState.EKSNodegroupName))
+		asgState.InstanceRefreshID = aws.StringValue(update.Id)
+		asgState.Status = aws.StringValue(update.Status)
+		return nil
+	}
+
+	r.s.Scope.Logger.Info(fmt.Sprintf("Waiting for EKS node group %s to finish updating.", asgState.EKSNodegroupName))
+	return nil
+}
+
+// Progress updates the status of the update of the EKS node group
+// backing the given ASG. EKS does not report the progress of updates, it is
+// estimated from the instances of the ASG which do not run its launch template
+// version yet.
+func (r *nodegroupRoller) Progress(ctx context.Context, asgState *ASGState) error {
+	output, err := r.s.EKS.Client.DescribeUpdate(&eks.DescribeUpdateInput{
+		Name:          aws.String(asgState.EKSClusterName),
+		NodegroupName: aws.String(asgState.EKSNodegroupName),
+		UpdateId:      aws.String(asgState.InstanceRefreshID),
+	})
+	if err != nil {
+		r.s.Scope.Logger.Error(err, "failed to describe EKS node group update")
+		return err
+	}
+	update := output.Update
+	asgState.Status = aws.StringValue(update.Status)
+	var reasons []string
+	for _, e := range update.Errors {
+		reasons = append(reasons, aws.StringValue(e.ErrorMessage))
+	}
+	asgState.StatusReason = strings.Join(reasons, " ")
+
+	if asgState.Status == eks.UpdateStatusSuccessful {
+		asgState.PercentageComplete = 100
+		asgState.InstancesRemaining = 0
+	} else {
+		asgOutput, err := r.s.ASG.Client.DescribeAutoScalingGroups(&autoscaling.DescribeAutoScalingGroupsInput{
+			AutoScalingGroupNames: []*string{aws.String(asgState.Name)},
+		})
+		if err != nil {
+			r.s.Scope.Logger.Error(err, "failed to describe autoscaling group")
+			return err
+		}
+		if len(asgOutput.AutoScalingGroups) > 0 {
+			asgState.InstancesRemaining = outdatedInstances(asgOutput.AutoScalingGroups[0])
+		}
+		if asgState.InstancesRemaining > asgState.InstancesToUpdate {
+			asgState.InstancesToUpdate = asgState.InstancesRemaining
+		}
+		asgState.PercentageComplete = 0
+		if asgState.InstancesToUpdate > 0 {
+			asgState.PercentageComplete = (asgState.InstancesToUpdate - asgState.InstancesRemaining) * 100 / asgState.InstancesToUpdate
+		}
+	}
+
+	switch asgState.Status {
+	case eks.UpdateStatusSuccessful:
+		r.s.Scope.Logger.Info(fmt.Sprintf("Successfully updated EKS node group %s of ASG %s", asgState.EKSNodegroupName, asgState.Name))
+	case eks.UpdateStatusFailed, eks.UpdateStatusCancelled:
+		r.s.Scope.Logger.Info(fmt.Sprintf("Updating EKS node group %s of ASG %s did not succeed, Status: %s. %s",
+			asgState.EKSNodegroupName, asgState.Name, asgState.Status, asgState.StatusReason))
+	default:
+		r.s.Scope.Logger.Info(fmt.Sprintf("Updating EKS node group %s of ASG %s, Status: %s, %d%% complete, %d instances remaining.",
+			asgState.EKSNodegroupName, asgState.Name, asgState.Status, asgState.PercentageComplete, asgState.InstancesRemaining))
+	}
+	return nil
+}
+
+// launchTemplate references the launch template of a node group in
+// the given version. EKS only accepts version numbers, so $Latest and
+// $Default are resolved.
+func (r *nodegroupRoller) launchTemplate(current *eks.LaunchTemplateSpecification, version string) (*eks.LaunchTemplateSpecification, error) {
+	spec := &eks.LaunchTemplateSpecification{}
+	input := &ec2.DescribeLaunchTemplatesInput{}
+	name := aws.StringValue(current.Id)
+	if current.Id != nil {
+		spec.Id = current.Id
+		input.LaunchTemplateIds = []*string{current.Id}
+	} else {
+		name = aws.StringValue(current.Name)
+		spec.Name = current.Name
+		input.LaunchTemplateNames = []*string{current.Name}
+	}
+	if isVersionNumber(&version) {
+		spec.Version = aws.String(version)
+		return spec, nil
+	}
+
+	output, err := r.s.EKS.EC2.DescribeLaunchTemplates(input)
+	if err != nil {
+		r.s.Scope.Logger.Error(err, "failed to describe launch template")
+		return nil, err
+	}
+	if len(output.LaunchTemplates) == 0 {
+		return nil, fmt.Errorf("Launch template %s not found", name)
+	}
+	number := output.LaunchTemplates[0].LatestVersionNumber
+	if version == "$Default" {
+		number = output.LaunchTemplates[0].DefaultVersionNumber
+	}
+	spec.Version = aws.String(strconv.FormatInt(aws.Int64Value(number), 10))
+	return spec, nil
+}
+
+// releaseVersion returns the latest AMI release for the Kubernetes version
+// and AMI type of the given node group. It is empty for AMI types whose
+// releases are not published to SSM, EKS picks the release then.
+func (r *nodegroupRoller) releaseVersion(nodegroup *eks.Nodegroup) (string, error) {
+	parameter, ok := releaseVersionParameters[aws.StringValue(nodegroup.AmiType)]
+	if !ok {
+		return "", nil
+	}
+	output, err := r.s.EKS.SSM.GetParameter(&ssm.GetParameterInput{
+		Name: aws.String(fmt.Sprintf(parameter, aws.StringValue(nodegroup.Version))),
+	})
+	if err != nil {
+		r.s.Scope.Logger.Error(err, "failed to get latest AMI release of EKS node group")
+		return "", err
+	}
+	return aws.StringValue(output.Parameter.Value), nil
+}
+
+// Cancel does not cancel anything, as EKS updates can not be cancelled. The
+// returned error makes the caller track the update until it ended.
+func (r *nodegroupRoller) Cancel(ctx context.Context, asgState *ASGState) error {
+	return microerror.Maskf(notCancellableError, "Updates of EKS node groups can not be cancelled, waiting for the update of node group %s of ASG %s to end.",
+		asgState.EKSNodegroupName, asgState.Name)
+}
+
+// nodegroupUpdateConfig returns the update config of node groups for the given
+// preferences.
+func nodegroupUpdateConfig(preferences Preferences) *eks.NodegroupUpdateConfig {
+	if preferences.MaxUnavailable > 0 {
+		return &eks.NodegroupUpdateConfig{MaxUnavailable: aws.Int64(preferences.MaxUnavailable)}
+	}
+	percentage := 100 - preferences.MinHealthyPercentage
+	if percentage < 1 {
+		percentage = 1
+	}
+	return &eks.NodegroupUpdateConfig{MaxUnavailablePercentage: aws.Int64(percentage)}
+}
+
+func matchesUpdateConfig(current, desired *eks.NodegroupUpdateConfig) bool {
+	if current == nil {
+		return false
+	}
+	return aws.Int64Value(current.MaxUnavailable) == aws.Int64Value(desired.MaxUnavailable) &&
+		aws.Int64Value(current.MaxUnavailablePercentage) == aws.Int64Value(desired.MaxUnavailablePercentage)
+}
+
+// outdatedInstances returns the number of instances of the given ASG which do
+// not run the launch template version of the ASG.
+func outdatedInstances(asg *autoscaling.Group) int64 {
+	current := launchTemplate(asg)
+	if current == nil {
+		return 0
+	}
+	var outdated int64
+	for _, instance := range asg.Instances {
+		if instance.LaunchTemplate == nil || aws.StringValue(instance.LaunchTemplate.Version) != aws.StringValue(current.Version) {
+			outdated++
+		}
+	}
+	return outdated
+}
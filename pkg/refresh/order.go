@@ -0,0 +1,108 @@
+package refresh
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	infrastructurev1alpha3 "github.com/giantswarm/apiextensions/v6/pkg/apis/infrastructure/v1alpha3"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+
+	"github.com/giantswarm/aws-rolling-node-operator/pkg/capi"
+	"github.com/giantswarm/aws-rolling-node-operator/pkg/key"
+)
+
+// plan sorts the given ASGs into the order they get refreshed in. Control
+// plane ASGs come first, followed by the node pools ordered by the priority
+// annotation of their AWSMachineDeployment. Ties are broken by ASG name so
+// the order is deterministic. Every group of ASGs sharing the same priority
+// forms a stage which only starts once the previous one is done.
+func (s *InstanceRefreshService) plan(ctx context.Context, asgs []*autoscaling.Group) []ASGState {
+	mdList := &infrastructurev1alpha3.AWSMachineDeploymentList{}
+	err := s.Client.List(ctx, mdList,
+		client.InNamespace(s.Scope.ClusterNamespace()),
+		client.MatchingLabels{key.ClusterLabel: s.Scope.ClusterName()},
+	)
+	if err != nil {
+		// Fall back to the default priority rather than blocking the refresh.
+		s.Scope.Logger.Error(err, "failed to list AWSMachineDeployments")
+	}
+
+	priorities := map[string]int64{}
+	for i := range mdList.Items {
+		md := &mdList.Items[i]
+		priority, err := key.InstanceRefreshPriority(md)
+		if err != nil {
+			s.Scope.Logger.Info(fmt.Sprintf("Invalid instance refresh priority on AWSMachineDeployment %s, using default %d",
+				md.Name, key.DefaultInstanceRefreshPriority))
+		}
+		priorities[key.MachineDeployment(md)] = priority
+	}
+
+	priority := func(asg *autoscaling.Group) int64 {
+		md, ok := asgTag(asg, key.MachineDeploymentLabel)
+		if !ok {
+			return key.DefaultInstanceRefreshPriority
+		}
+		if p, ok := priorities[md]; ok {
+			return p
+		}
+		return key.DefaultInstanceRefreshPriority
+	}
+
+	sort.SliceStable(asgs, func(i, j int) bool {
+		iCP, jCP := isControlPlane(asgs[i]), isControlPlane(asgs[j])
+		if iCP != jCP {
+			return iCP
+		}
+		iPriority, jPriority := priority(asgs[i]), priority(asgs[j])
+		if iPriority != jPriority {
+			return iPriority < jPriority
+		}
+		return *asgs[i].AutoScalingGroupName < *asgs[j].AutoScalingGroupName
+	})
+
+	states := []ASGState{}
+	for i, asg := range asgs {
+		asgState := ASGState{
+			Name:         *asg.AutoScalingGroupName,
+			ControlPlane: isControlPlane(asg),
+		}
+		// ASGs of EKS managed node groups are rolled by updating the node
+		// group.
+		eksCluster, _ := asgTag(asg, capi.EKSClusterNameTag)
+		nodegroup, _ := asgTag(asg, capi.EKSNodegroupNameTag)
+		if eksCluster != "" && nodegroup != "" {
+			asgState.EKSClusterName = eksCluster
+			asgState.EKSNodegroupName = nodegroup
+		}
+		if i > 0 {
+			previous := states[i-1]
+			asgState.Stage = previous.Stage
+			if previous.ControlPlane != asgState.ControlPlane || priority(asgs[i-1]) != priority(asg) {
+				asgState.Stage++
+			}
+		}
+		states = append(states, asgState)
+	}
+	return states
+}
+
+func isControlPlane(asg *autoscaling.Group) bool {
+	_, ok := asgTag(asg, key.ControlPlaneLabel)
+	return ok
+}
+
+func asgTag(asg *autoscaling.Group, tagKey string) (string, bool) {
+	for _, tag := range asg.Tags {
+		if tag.Key != nil && *tag.Key == tagKey {
+			if tag.Value == nil {
+				return "", true
+			}
+			return *tag.Value, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,74 @@
+package refresh
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/giantswarm/microerror"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/giantswarm/aws-rolling-node-operator/pkg/workload"
+)
+
+// disruptionsBlocked returns true if PodDisruptionBudgets of the workload
+// cluster currently allow no disruptions of pods on the nodes of the given
+// ASG. An instance refresh would wedge on them, so it is delayed until the
+// PodDisruptionBudgets allow disruptions again, for at most
+// CheckPDBsTimeoutSeconds, after which the ASG fails. The check is skipped if
+// the workload cluster has no kubeconfig to reach it with.
+func (s *InstanceRefreshService) disruptionsBlocked(ctx context.Context, asgState *ASGState, asg *autoscaling.Group, preferences Preferences) (bool, error) {
+	k8sClient, err := s.workloadCluster(ctx)
+	if IsTransient(err) {
+		return false, err
+	} else if err != nil {
+		s.Scope.Logger.Info(fmt.Sprintf("Skipping the PodDisruptionBudget check of ASG %s: %s", asgState.Name, err))
+		return false, nil
+	}
+
+	nodes, err := workload.NodesByInstanceID(ctx, k8sClient)
+	if err != nil {
+		return false, microerror.Maskf(transientError, "failed to list nodes of ASG %s: %s", asgState.Name, err)
+	}
+	var nodeNames []string
+	for _, instance := range asg.Instances {
+		if node, ok := nodes[aws.StringValue(instance.InstanceId)]; ok {
+			nodeNames = append(nodeNames, node.Name)
+		}
+	}
+	blocking, err := workload.BlockingPodDisruptionBudgets(ctx, k8sClient, nodeNames)
+	if err != nil {
+		return false, microerror.Maskf(transientError, "failed to check PodDisruptionBudgets of ASG %s: %s", asgState.Name, err)
+	}
+	if len(blocking) == 0 {
+		asgState.BlockedSince = nil
+		return false, nil
+	}
+
+	if asgState.BlockedSince == nil {
+		now := metav1.Now()
+		asgState.BlockedSince = &now
+	}
+	timeout := time.Duration(preferences.CheckPDBsTimeoutSeconds) * time.Second
+	if time.Since(asgState.BlockedSince.Time) >= timeout {
+		asgState.Status = autoscaling.InstanceRefreshStatusFailed
+		asgState.StatusReason = fmt.Sprintf("PodDisruptionBudgets %s allowed no disruptions of pods on the nodes of ASG %s for %s, giving up.",
+			strings.Join(blocking, ", "), asgState.Name, timeout)
+		s.Scope.Logger.Info(asgState.StatusReason)
+		s.event(v1.EventTypeWarning, "InstanceRefreshBlockedTimedOut", asgState.StatusReason)
+		return true, nil
+	}
+
+	reason := fmt.Sprintf("PodDisruptionBudgets %s allow no disruptions of pods on the nodes of ASG %s, delaying the instance refresh.",
+		strings.Join(blocking, ", "), asgState.Name)
+	if reason != asgState.StatusReason {
+		asgState.StatusReason = reason
+		s.Scope.Logger.Info(reason)
+		s.event(v1.EventTypeWarning, "InstanceRefreshBlocked", reason)
+	}
+	return true, nil
+}
@@ -0,0 +1,34 @@
+package refresh
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/giantswarm/microerror"
+	v1 "k8s.io/api/core/v1"
+)
+
+// healthQuery evaluates the health query of the instance refresh, which is
+// true if none is set. Failing to evaluate it is transient.
+func (s *InstanceRefreshService) healthQuery(ctx context.Context, preferences Preferences) (bool, error) {
+	if preferences.HealthQuery == "" {
+		return true, nil
+	}
+	if s.Prometheus == nil {
+		return false, fmt.Errorf("Health query %q can not be evaluated without a Prometheus address", preferences.HealthQuery)
+	}
+	ok, err := s.Prometheus.Evaluate(ctx, preferences.HealthQuery)
+	if err != nil {
+		return false, microerror.Maskf(transientError, "Failed to evaluate health query %q: %s", preferences.HealthQuery, err)
+	}
+	return ok, nil
+}
+
+// stop cancels all rolls in flight because the health query evaluated to
+// false and reports the query.
+func (s *InstanceRefreshService) stop(ctx context.Context, state *State, preferences Preferences, when string) (bool, error) {
+	message := fmt.Sprintf("Health query %q evaluated to false %s, cancelling the instance refresh.", preferences.HealthQuery, when)
+	s.Scope.Logger.Info(message)
+	s.event(v1.EventTypeWarning, "InstanceRefreshHealthQueryFailed", message)
+	return s.cancel(ctx, state)
+}
@@ -3,218 +3,705 @@ package refresh
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
-	"k8s.io/apimachinery/pkg/types"
+	"github.com/giantswarm/microerror"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
-	infrastructurev1alpha3 "github.com/giantswarm/apiextensions/v6/pkg/apis/infrastructure/v1alpha3"
-
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
 
-	"github.com/cenkalti/backoff/v4"
-
 	"github.com/giantswarm/aws-rolling-node-operator/pkg/aws/scope"
 	"github.com/giantswarm/aws-rolling-node-operator/pkg/aws/services/asg"
+	eksservice "github.com/giantswarm/aws-rolling-node-operator/pkg/aws/services/eks"
 	"github.com/giantswarm/aws-rolling-node-operator/pkg/key"
+	metrics "github.com/giantswarm/aws-rolling-node-operator/pkg/metrics"
+	"github.com/giantswarm/aws-rolling-node-operator/pkg/promql"
+	"github.com/giantswarm/aws-rolling-node-operator/pkg/workload"
 )
 
+// progressEventInterval is the minimum time between two progress events of
+// the same ASG.
+const progressEventInterval = 5 * time.Minute
+
 type InstanceRefreshService struct {
 	Client client.Client
 	Scope  *scope.ClusterScope
 
+	// Object is the InstanceRefresh progress events are recorded on.
+	Object   runtime.Object
+	Recorder record.EventRecorder
+
+	// ClusterTags select the ASGs of the cluster. They default to the
+	// giantswarm.io/cluster tag.
+	ClusterTags map[string]string
+
 	ASG *asg.Service
+	EKS *eksservice.Service
+
+	// Rollers replace the instances of the ASGs by name. They default to
+	// the rollers backed by AWS.
+	Rollers map[string]Roller
+
+	// WorkloadCluster connects to the workload cluster. It is only called
+	// by rollers which drain nodes.
+	WorkloadCluster func(ctx context.Context) (kubernetes.Interface, error)
+	workloadClient  kubernetes.Interface
+
+	// Prometheus evaluates the health query, if one is set.
+	Prometheus *promql.Client
+}
+
+// Preferences are the settings used when starting an instance refresh.
+type Preferences struct {
+	MinHealthyPercentage   int64
+	InstanceWarmupSeconds  int64
+	MaxParallelASGs        int64
+	CheckpointPercentages  []int64
+	CheckpointDelaySeconds int64
+	// RequireApproval holds the instance refresh at every checkpoint until
+	// it got approved.
+	RequireApproval    bool
+	ApprovedCheckpoint int64
+	SkipMatching       bool
+	// LaunchTemplateVersion is a version number, $Latest or $Default.
+	LaunchTemplateVersion string
+	// Rollback rolls the instances of an ASG back to the launch template
+	// version they ran before, if its instance refresh failed.
+	Rollback bool
+	// CooldownSeconds is the time after the last instance refresh of an ASG
+	// during which it is skipped. Force refreshes it regardless.
+	CooldownSeconds int64
+	Force           bool
+	// Strategy is passed to AWS, it defaults to Rolling.
+	Strategy string
+	// Cancel cancels all instance refreshes in flight.
+	Cancel bool
+	// Roller is the name of the roller replacing the instances of all ASGs.
+	// By default it is picked per ASG, see Roller.
+	Roller string
+	// Surge is the number of instances the Surge roller launches ahead of
+	// draining and terminating as many outdated ones.
+	Surge int64
+	// MaxUnavailable is the maximum number of nodes of an EKS managed node
+	// group which are unavailable while it gets updated. If unset, the share
+	// of nodes MinHealthyPercentage allows to be unavailable is used.
+	MaxUnavailable int64
+	// Drain holds the instances an instance refresh terminates with a
+	// lifecycle hook until their nodes got drained, for at most
+	// DrainTimeoutSeconds.
+	Drain               bool
+	DrainTimeoutSeconds int64
+	// CheckPDBs delays instance refreshes while PodDisruptionBudgets allow
+	// no disruptions of pods on the nodes of an ASG, for at most
+	// CheckPDBsTimeoutSeconds.
+	CheckPDBs               bool
+	CheckPDBsTimeoutSeconds int64
+	// HealthCheck holds back the next ASGs until the nodes of a refreshed
+	// ASG are Ready and the kube-system DaemonSets are rolled out, for at
+	// most HealthCheckTimeoutSeconds.
+	HealthCheck               bool
+	HealthCheckTimeoutSeconds int64
+	// HealthQuery is a PromQL expression which has to evaluate to true
+	// before an ASG gets refreshed and at every checkpoint. Otherwise the
+	// instance refresh gets cancelled.
+	HealthQuery string
+	// MaintenanceWindow restricts the times ASGs get started in, see
+	// window.Parse. MaintenanceWindowPolicy decides whether instance
+	// refreshes in progress finish the rolls in flight or get cancelled
+	// when it closes.
+	MaintenanceWindow       string
+	MaintenanceWindowPolicy string
 }
 
-func New(scope *scope.ClusterScope, client client.Client) *InstanceRefreshService {
+func New(scope *scope.ClusterScope, client client.Client, recorder record.EventRecorder, obj runtime.Object) *InstanceRefreshService {
 	return &InstanceRefreshService{
 		Scope:  scope,
 		Client: client,
 
+		Object:   obj,
+		Recorder: recorder,
+
 		ASG: asg.NewService(scope),
+		EKS: eksservice.NewService(scope),
 	}
 }
 
-func (s *InstanceRefreshService) Refresh(ctx context.Context, minHealthyPercentage, instanceWarmupSeconds int64, asgFilter map[string]string, startRefresh chan bool) error {
-	asgInput := &autoscaling.DescribeAutoScalingGroupsInput{
-		// default filter for ASGs
-		Filters: []*autoscaling.Filter{
-			{
-				Name:   aws.String("tag-key"),
-				Values: []*string{aws.String("giantswarm.io/cluster")},
-			},
-			{
-				Name:   aws.String("tag-value"),
-				Values: []*string{aws.String(s.Scope.ClusterName())},
-			},
-		},
-	}
-
-	// addtional filter for ASG, depending what ASG you wanna roll specifically (certain nodepools or controlplanes)
-	for k, v := range asgFilter {
-		filter := []*autoscaling.Filter{
-			{
-				Name:   aws.String("tag-key"),
-				Values: []*string{aws.String(k)},
-			},
-			{
-				Name:   aws.String("tag-value"),
-				Values: []*string{aws.String(v)},
-			},
-		}
-		asgInput.Filters = append(asgInput.Filters, filter...)
+// Refresh advances the instance refresh described by state by a single step.
+// It starts the refresh of the next ASGs or inspects the ones in flight and
+// never waits for AWS. It returns true once the refresh is finished, until
+// then callers are expected to persist state and call Refresh again.
+//
+// ASGs are refreshed stage by stage. Control plane ASGs are always refreshed
+// one at a time, node pool ASGs of the same stage concurrently up to
+// MaxParallelASGs. Failures are collected until all ASGs in flight finished
+// and then reported as a single error. Failed ASGs get rolled back first if
+// requested, rollers which changed their configuration restore it.
+//
+// Outside of the maintenance window no ASGs are started and the instance
+// refresh is queued once the ones in flight finished, unless the policy
+// cancels instance refreshes in progress.
+func (s *InstanceRefreshService) Refresh(ctx context.Context, state *State, preferences Preferences, asgFilter map[string]string) (bool, error) {
+	if state.ASGs == nil {
+		asgs, err := s.describeAutoScalingGroups(asgFilter)
+		if err != nil {
+			return false, err
+		}
+		state.ASGs = s.plan(ctx, asgs)
+		for i := range state.ASGs {
+			state.ASGs[i].Roller = preferences.Roller
+			if state.ASGs[i].Roller == "" {
+				state.ASGs[i].Roller = detectRoller(&state.ASGs[i])
+			}
+		}
 	}
 
-	asgOutput, err := s.ASG.Client.DescribeAutoScalingGroups(asgInput)
+	if preferences.Cancel || state.Phase == PhaseCancelling {
+		return s.cancel(ctx, state)
+	}
+
+	w, err := maintenanceWindow(preferences)
 	if err != nil {
-		s.Scope.Logger.Error(err, "failed to describe autoscaling group")
-		return err
+		return false, err
+	}
+	open := w == nil || w.Open(time.Now())
+	if !open && preferences.MaintenanceWindowPolicy == MaintenanceWindowPolicyCancel &&
+		(state.Phase == PhaseInProgress || state.Phase == PhaseAwaitingApproval) {
+		return s.closeWindow(ctx, state, w)
 	}
 
-	for i, asg := range asgOutput.AutoScalingGroups {
-		refreshStatus := &autoscaling.DescribeInstanceRefreshesInput{
-			AutoScalingGroupName: asg.AutoScalingGroupName,
+	stage := -1
+	queued := false
+	inFlight := 0
+	awaitingApproval := 0
+	var failed []ASGState
+	for i := range state.ASGs {
+		asgState := &state.ASGs[i]
+		if asgState.Finished() {
+			continue
 		}
-
-		output, err := s.ASG.Client.DescribeInstanceRefreshes(refreshStatus)
-		if err != nil {
-			s.Scope.Logger.Error(err, "failed to describe instance refreshes")
-			return err
-
+		if asgState.Failed() {
+			err := s.restore(ctx, asgState)
+			if err != nil {
+				return false, err
+			}
+			failed = append(failed, *asgState)
+			continue
 		}
-		if len(output.InstanceRefreshes) > 0 {
-			if output.InstanceRefreshes[0].EndTime != nil {
-				if !output.InstanceRefreshes[0].EndTime.UTC().Before(time.Now().UTC().Add(-30 * time.Minute)) {
-					s.Scope.Logger.Info(
-						fmt.Sprintf("ASG %s already refreshed within the last 30 minutes, skipping...",
-							*asg.AutoScalingGroupName))
-					continue
+
+		if asgState.Status == ASGStatusAwaitingApproval {
+			if asgState.Checkpoint <= preferences.ApprovedCheckpoint {
+				err := s.resume(ctx, asgState, preferences)
+				if err != nil {
+					return false, err
+				}
+			}
+		} else if asgState.InstanceRefreshID != "" {
+			if asgState.Status != ASGStatusVerifying {
+				checkpoint := asgState.Checkpoint
+				err := s.inspect(ctx, asgState)
+				if err != nil {
+					return false, err
+				}
+				if asgState.Checkpoint > checkpoint {
+					ok, err := s.healthQuery(ctx, preferences)
+					if err != nil {
+						return false, err
+					}
+					if !ok {
+						return s.stop(ctx, state, preferences, fmt.Sprintf("at checkpoint %d%% of ASG %s", asgState.Checkpoint, asgState.Name))
+					}
+				}
+				err = s.rollback(ctx, asgState, preferences)
+				if err != nil {
+					return false, err
+				}
+			}
+			err := s.verify(ctx, asgState, preferences)
+			if err != nil {
+				return false, err
+			}
+			if asgState.Failed() {
+				err = s.restore(ctx, asgState)
+				if err != nil {
+					return false, err
 				}
+				failed = append(failed, *asgState)
+				continue
 			}
+			if asgState.Finished() {
+				continue
+			}
+			err = s.hold(ctx, asgState, preferences)
+			if err != nil {
+				return false, err
+			}
+		}
+
+		if stage == -1 {
+			stage = asgState.Stage
+		}
+		// Later stages wait until the current one is done.
+		if asgState.Stage != stage {
+			break
 		}
 
-		if len(asg.Instances) == 0 {
-			s.Scope.Info(fmt.Sprintf("ASG %s has no instances, skipping...", *asg.AutoScalingGroupName))
+		if asgState.Status == ASGStatusAwaitingApproval {
+			awaitingApproval++
+			inFlight++
 			continue
 		}
+		if asgState.InstanceRefreshID != "" {
+			inFlight++
+		}
+	}
 
-		refreshInput := &autoscaling.StartInstanceRefreshInput{
-			AutoScalingGroupName: asg.AutoScalingGroupName,
-			DesiredConfiguration: &autoscaling.DesiredConfiguration{
-				LaunchTemplate: &autoscaling.LaunchTemplateSpecification{
-					LaunchTemplateId: asg.Instances[0].LaunchTemplate.LaunchTemplateId,
-					Version:          aws.String("$Latest"),
-				},
-			},
-			Preferences: &autoscaling.RefreshPreferences{
-				CheckpointDelay:       nil,
-				CheckpointPercentages: []*int64{},
-				InstanceWarmup:        aws.Int64(instanceWarmupSeconds),
-				MinHealthyPercentage:  aws.Int64(minHealthyPercentage),
-				SkipMatching:          nil,
-			},
-			Strategy: aws.String("Rolling"),
-		}
-		_, err = s.ASG.Client.StartInstanceRefresh(refreshInput)
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case autoscaling.ErrCodeInstanceRefreshInProgressFault:
-				s.Scope.Logger.Info(fmt.Sprintf("An instance refresh is already in progress for ASG %s.",
-					*asg.AutoScalingGroupName))
-			}
-		} else if err != nil {
-			s.Scope.Logger.Error(err, "failed to start instance refresh")
-			return err
-		} else {
-			if i == 0 {
-				startRefresh <- true
+	// ASGs are only started once all ASGs of the stage in flight are
+	// counted, as starts of earlier ASGs may have been deferred.
+	for i := range state.ASGs {
+		asgState := &state.ASGs[i]
+		if asgState.Stage != stage || asgState.Finished() || asgState.Failed() ||
+			asgState.Status == ASGStatusAwaitingApproval || asgState.InstanceRefreshID != "" {
+			continue
+		}
+		if len(failed) > 0 || inFlight >= maxParallel(*asgState, preferences) {
+			break
+		}
+		if !open {
+			queued = true
+			continue
+		}
+		ok, err := s.healthQuery(ctx, preferences)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			return s.stop(ctx, state, preferences, fmt.Sprintf("before refreshing ASG %s", asgState.Name))
+		}
+		err = s.start(ctx, asgState, preferences, false)
+		if err != nil {
+			return false, err
+		}
+		if asgState.Failed() {
+			failed = append(failed, *asgState)
+			continue
+		}
+		if asgState.InstanceRefreshID != "" {
+			state.Phase = PhaseInProgress
+			inFlight++
+		}
+	}
+
+	if awaitingApproval > 0 {
+		state.Phase = PhaseAwaitingApproval
+	} else if state.Phase == PhaseAwaitingApproval {
+		state.Phase = PhaseInProgress
+	}
+	if inFlight > 0 {
+		return false, nil
+	}
+	if len(failed) > 0 {
+		state.Phase = PhaseCancelled
+		var names []string
+		for _, f := range failed {
+			if f.Status != autoscaling.InstanceRefreshStatusCancelled {
+				state.Phase = PhaseFailed
 			}
+			names = append(names, fmt.Sprintf("%s (%s)", f.Name, f.Status))
 		}
+		return true, fmt.Errorf("Instance refresh did not succeed for ASG %s", strings.Join(names, ", "))
+	}
+	if queued {
+		s.queue(state, w)
+		return false, nil
+	}
+	if state.Remaining() {
+		return false, nil
+	}
 
-		b := backoff.NewConstantBackOff(30 * time.Second)
+	state.Phase = PhaseSuccessful
+	return true, nil
+}
 
-		waitonRefresh := func() error {
+func maxParallel(asgState ASGState, preferences Preferences) int {
+	if asgState.ControlPlane || preferences.MaxParallelASGs < 1 {
+		return 1
+	}
+	return int(preferences.MaxParallelASGs)
+}
 
-			if s.shouldCancel(ctx, asgFilter) {
-				cancelInput := &autoscaling.CancelInstanceRefreshInput{
-					AutoScalingGroupName: aws.String(*asg.AutoScalingGroupName),
-				}
-				_, err := s.ASG.Client.CancelInstanceRefresh(cancelInput)
-				if err != nil {
-					s.Scope.Logger.Error(err, "failed to cancel instance refresh")
-					return err
-				}
-				return backoff.Permanent(fmt.Errorf("Cancelled instance refresh for ASG %s", *asg.AutoScalingGroupName))
-			}
+func (s *InstanceRefreshService) describeAutoScalingGroups(asgFilter map[string]string) ([]*autoscaling.Group, error) {
+	clusterTags := s.ClusterTags
+	if clusterTags == nil {
+		// default filter for ASGs
+		clusterTags = map[string]string{key.ClusterLabel: s.Scope.ClusterName()}
+	}
 
-			output, err := s.ASG.Client.DescribeInstanceRefreshes(refreshStatus)
-			if err != nil {
-				s.Scope.Logger.Error(err, "failed to describe instance refreshes")
-				return err
-			}
-			if *output.InstanceRefreshes[0].Status == autoscaling.InstanceRefreshStatusSuccessful {
-				s.Scope.Logger.Info(fmt.Sprintf("Successfully refreshed all instances in ASG %s",
-					*asg.AutoScalingGroupName))
-				return nil
+	asgInput := &autoscaling.DescribeAutoScalingGroupsInput{}
+	// ASGs of the cluster, narrowed down by the addtional filter depending what ASG you wanna roll specifically (certain nodepools or controlplanes)
+	for _, tags := range []map[string]string{clusterTags, asgFilter} {
+		for k, v := range tags {
+			filter := []*autoscaling.Filter{
+				{
+					Name:   aws.String("tag-key"),
+					Values: []*string{aws.String(k)},
+				},
+				{
+					Name:   aws.String("tag-value"),
+					Values: []*string{aws.String(v)},
+				},
 			}
+			asgInput.Filters = append(asgInput.Filters, filter...)
+		}
+	}
 
-			if *output.InstanceRefreshes[0].Status == autoscaling.InstanceRefreshStatusCancelling {
-				s.Scope.Logger.Info(fmt.Sprintf("Cancelling refreshing instances in ASG %s",
-					*asg.AutoScalingGroupName))
-				return nil
-			}
+	asgOutput, err := s.ASG.Client.DescribeAutoScalingGroups(asgInput)
+	if err != nil {
+		s.Scope.Logger.Error(err, "failed to describe autoscaling group")
+		return nil, err
+	}
+	return asgOutput.AutoScalingGroups, nil
+}
 
-			if *output.InstanceRefreshes[0].Status == autoscaling.InstanceRefreshStatusCancelled {
-				s.Scope.Logger.Info(fmt.Sprintf("Cancelled refreshing instances in ASG %s",
-					*asg.AutoScalingGroupName))
-				return nil
-			}
+// start starts rolling the given ASG with its roller. The cooldown is not
+// applied when resuming or rolling back, which is what ignoreCooldown is for.
+func (s *InstanceRefreshService) start(ctx context.Context, asgState *ASGState, preferences Preferences, ignoreCooldown bool) error {
+	r, err := s.roller(asgState)
+	if err != nil {
+		return err
+	}
+	return r.Start(ctx, asgState, preferences, ignoreCooldown)
+}
 
-			s.Scope.Logger.Info(fmt.Sprintf("Refreshing instances in ASG %s, Status: %s",
-				*asg.AutoScalingGroupName,
-				*output.InstanceRefreshes[0].Status))
+// skip marks the given ASG as skipped and tells the user why.
+func (s *InstanceRefreshService) skip(asgState *ASGState, message string) {
+	asgState.Status = ASGStatusSkipped
+	s.Scope.Logger.Info(message)
+	s.event(v1.EventTypeNormal, "InstanceRefreshSkipped", message)
+}
+
+// inspect updates the status of the roll of the given ASG and reports its
+// progress.
+func (s *InstanceRefreshService) inspect(ctx context.Context, asgState *ASGState) error {
+	r, err := s.roller(asgState)
+	if err != nil {
+		return err
+	}
+	err = r.Progress(ctx, asgState)
+	if err != nil {
+		return err
+	}
+	s.progress(asgState)
+	return nil
+}
+
+// progress exposes the progress of the instance refresh of the given ASG as
+// metrics and reports it as an event at most every progressEventInterval, so
+// long instance refreshes do not flood the InstanceRefresh with events. The
+// metrics are removed once the instance refresh ended.
+func (s *InstanceRefreshService) progress(asgState *ASGState) {
+	switch asgState.Status {
+	case autoscaling.InstanceRefreshStatusSuccessful, autoscaling.InstanceRefreshStatusFailed, autoscaling.InstanceRefreshStatusCancelled:
+		asgState.ProgressReportedAt = nil
+		s.deleteMetrics(asgState.Name)
+		return
+	}
+
+	labels := s.metricLabels(asgState.Name)
+	metrics.InstanceRefreshPercentageComplete.WithLabelValues(labels...).Set(float64(asgState.PercentageComplete))
+	metrics.InstanceRefreshInstancesRemaining.WithLabelValues(labels...).Set(float64(asgState.InstancesRemaining))
+
+	now := metav1.Now()
+	if asgState.ProgressReportedAt == nil {
+		asgState.ProgressReportedAt = &now
+		return
+	}
+	if now.Sub(asgState.ProgressReportedAt.Time) < progressEventInterval {
+		return
+	}
+	asgState.ProgressReportedAt = &now
+
+	message := fmt.Sprintf("ASG %s is %d%% refreshed, %d instances remaining, Status: %s.",
+		asgState.Name, asgState.PercentageComplete, asgState.InstancesRemaining, asgState.Status)
+	if asgState.StatusReason != "" {
+		message = fmt.Sprintf("%s %s", message, asgState.StatusReason)
+	}
+	s.event(v1.EventTypeNormal, "InstanceRefreshProgress", message)
+}
+
+func (s *InstanceRefreshService) metricLabels(name string) []string {
+	return []string{s.Scope.Installation(), s.Scope.AccountID(), s.Scope.ClusterName(), s.Scope.ClusterNamespace(), name}
+}
 
-			return fmt.Errorf("ASG %s is not ready yet", *asg.AutoScalingGroupName)
+func (s *InstanceRefreshService) deleteMetrics(name string) {
+	labels := s.metricLabels(name)
+	metrics.InstanceRefreshPercentageComplete.DeleteLabelValues(labels...)
+	metrics.InstanceRefreshInstancesRemaining.DeleteLabelValues(labels...)
+}
+
+// hold cancels the instance refresh of the given ASG once it reached a
+// checkpoint which has not been approved yet. The ASG then awaits approval.
+// AWS ends instance refreshes at their last checkpoint, so there is nothing
+// left to approve there.
+func (s *InstanceRefreshService) hold(ctx context.Context, asgState *ASGState, preferences Preferences) error {
+	if !preferences.RequireApproval || asgState.Checkpoint == 0 || asgState.Checkpoint >= 100 {
+		return nil
+	}
+	if n := len(preferences.CheckpointPercentages); n > 0 && asgState.Checkpoint >= preferences.CheckpointPercentages[n-1] {
+		return nil
+	}
+	if asgState.Checkpoint <= preferences.ApprovedCheckpoint {
+		return nil
+	}
+
+	r, err := s.roller(asgState)
+	if err != nil {
+		return err
+	}
+	err = r.Cancel(ctx, asgState)
+	if err != nil {
+		return err
+	}
+	asgState.Status = ASGStatusAwaitingApproval
+
+	message := fmt.Sprintf("ASG %s reached checkpoint %d%% and awaits approval. Approve checkpoint %d%% to continue.",
+		asgState.Name, asgState.Checkpoint, asgState.Checkpoint)
+	s.Scope.Logger.Info(message)
+	s.event(v1.EventTypeNormal, "InstanceRefreshAwaitingApproval", message)
+	return nil
+}
+
+// resume restarts the instance refresh of an ASG which got held at an
+// approved checkpoint. Instances which already run the desired configuration
+// are skipped. The remaining checkpoints are carried over, relative to the
+// instances left at the approved one. The ASG keeps awaiting approval until
+// the previous instance refresh got cancelled.
+func (s *InstanceRefreshService) resume(ctx context.Context, asgState *ASGState, preferences Preferences) error {
+	resumed := preferences
+	resumed.SkipMatching = true
+	resumed.CheckpointPercentages = resumedCheckpoints(preferences.CheckpointPercentages, asgState.Checkpoint)
+
+	held := *asgState
+	asgState.InstanceRefreshID = ""
+	asgState.Status = ""
+	asgState.ResumedAt = asgState.Checkpoint
+	err := s.start(ctx, asgState, resumed, true)
+	if err != nil || asgState.InstanceRefreshID == "" {
+		*asgState = held
+		return err
+	}
+
+	message := fmt.Sprintf("Checkpoint %d%% of ASG %s got approved, continuing instance refresh.", asgState.Checkpoint, asgState.Name)
+	s.Scope.Logger.Info(message)
+	s.event(v1.EventTypeNormal, "InstanceRefreshApproved", message)
+	return nil
+}
+
+// resumedCheckpoints returns the checkpoints after the given one relative to
+// the instances left at it, which is how AWS counts the checkpoints of an
+// instance refresh resumed at it. They are rounded up, so none is reached
+// early.
+func resumedCheckpoints(checkpoints []int64, resumedAt int64) []int64 {
+	var resumed []int64
+	for _, c := range checkpoints {
+		if c > resumedAt {
+			resumed = append(resumed, ((c-resumedAt)*100+99-resumedAt)/(100-resumedAt))
 		}
-		err = backoff.Retry(waitonRefresh, b)
-		if err != nil {
-			s.Scope.Logger.Error(err, "refreshing instances failed")
-			return err
+	}
+	return resumed
+}
+
+// fromResumed returns the given percentage of an instance refresh resumed at
+// the given checkpoint relative to all instances of the ASG.
+func fromResumed(percentage, resumedAt int64) int64 {
+	return resumedAt + percentage*(100-resumedAt)/100
+}
+
+// rollback starts rolling back the instances of the given ASG to the launch
+// template version they ran before, once its instance refresh failed. Only
+// instances which do not run that version yet get replaced. The outcome of
+// the rollback is reported once its instance refresh ended, the ASG then
+// counts as failed either way.
+func (s *InstanceRefreshService) rollback(ctx context.Context, asgState *ASGState, preferences Preferences) error {
+	if asgState.RollingBack {
+		switch {
+		case asgState.Status == autoscaling.InstanceRefreshStatusSuccessful:
+			asgState.Status = ASGStatusRolledBack
+			message := fmt.Sprintf("Rolled back ASG %s to launch template version %s.", asgState.Name, asgState.PreviousLaunchTemplateVersion)
+			s.Scope.Logger.Info(message)
+			s.event(v1.EventTypeNormal, "InstanceRefreshRolledBack", message)
+		case asgState.Failed():
+			message := fmt.Sprintf("Rolling back ASG %s to launch template version %s did not succeed, Status: %s.",
+				asgState.Name, asgState.PreviousLaunchTemplateVersion, asgState.Status)
+			asgState.Status = ASGStatusRollbackFailed
+			s.Scope.Logger.Info(message)
+			s.event(v1.EventTypeWarning, "InstanceRefreshRollbackFailed", message)
 		}
+		return nil
+	}
+	if !preferences.Rollback || !asgState.Failed() {
+		return nil
+	}
+	if asgState.PreviousLaunchTemplateVersion == "" {
+		s.Scope.Logger.Info(fmt.Sprintf("Previous launch template version of ASG %s is unknown, not rolling back.", asgState.Name))
+		return nil
+	}
+
+	rolledBack := preferences
+	rolledBack.LaunchTemplateVersion = asgState.PreviousLaunchTemplateVersion
+	rolledBack.SkipMatching = true
+	rolledBack.CheckpointPercentages = nil
+	rolledBack.RequireApproval = false
+
+	failed := *asgState
+	asgState.InstanceRefreshID = ""
+	asgState.Status = ""
+	asgState.Checkpoint = 0
+	asgState.ResumedAt = 0
+	asgState.InstancesToUpdate = 0
+	err := s.start(ctx, asgState, rolledBack, true)
+	if err != nil || asgState.InstanceRefreshID == "" {
+		*asgState = failed
+		return err
 	}
+	asgState.RollingBack = true
+
+	message := fmt.Sprintf("Instance refresh of ASG %s did not succeed, Status: %s. Rolling back to launch template version %s.",
+		asgState.Name, failed.Status, asgState.PreviousLaunchTemplateVersion)
+	s.Scope.Logger.Info(message)
+	s.event(v1.EventTypeWarning, "InstanceRefreshFailed", message)
 	return nil
 }
 
-func (s *InstanceRefreshService) shouldCancel(ctx context.Context, asgFilter map[string]string) bool {
-	if asgFilter != nil {
-		if v, ok := asgFilter[key.ControlPlaneLabel]; ok {
-			cp := &infrastructurev1alpha3.AWSControlPlane{}
-			err := s.Client.Get(ctx, types.NamespacedName{Name: v, Namespace: s.Scope.ClusterNamespace()}, cp)
+// workloadCluster returns a client of the workload cluster. Failing to reach
+// it is transient, unless its kubeconfig is missing or invalid.
+func (s *InstanceRefreshService) workloadCluster(ctx context.Context) (kubernetes.Interface, error) {
+	if s.workloadClient != nil {
+		return s.workloadClient, nil
+	}
+	if s.WorkloadCluster == nil {
+		return nil, fmt.Errorf("Workload cluster %s is not reachable", s.Scope.ClusterName())
+	}
+	k8sClient, err := s.WorkloadCluster(ctx)
+	if workload.IsInvalidConfig(err) || errors.IsNotFound(err) {
+		return nil, err
+	} else if err != nil {
+		return nil, microerror.Maskf(transientError, "Workload cluster %s is not reachable: %s", s.Scope.ClusterName(), err)
+	}
+	s.workloadClient = k8sClient
+	return k8sClient, nil
+}
+
+// requireWorkloadCluster fails the given ASG before its roll started if the
+// workload cluster has no kubeconfig to reach it with, as the roll has to drain
+// its nodes.
+func (s *InstanceRefreshService) requireWorkloadCluster(ctx context.Context, asgState *ASGState) (bool, error) {
+	_, err := s.workloadCluster(ctx)
+	if IsTransient(err) {
+		return false, err
+	} else if err != nil {
+		message := fmt.Sprintf("Can not drain the nodes of ASG %s: %s", asgState.Name, err)
+		s.Scope.Logger.Info(message)
+		s.event(v1.EventTypeWarning, "WorkloadClusterUnreachable", message)
+		asgState.Status = autoscaling.InstanceRefreshStatusFailed
+		asgState.StatusReason = message
+		return false, nil
+	}
+	return true, nil
+}
+
+// event records an event on the CR the instance refresh got requested on.
+func (s *InstanceRefreshService) event(eventtype, reason, message string) {
+	if s.Recorder == nil || s.Object == nil {
+		return
+	}
+	s.Recorder.Event(s.Object, eventtype, reason, message)
+}
+
+// cancel cancels all rolls which are currently in flight. Rolls which can not
+// be cancelled are tracked until they ended, the instance refresh is
+// Cancelling until then.
+func (s *InstanceRefreshService) cancel(ctx context.Context, state *State) (bool, error) {
+	var names, tracked []string
+	for i := range state.ASGs {
+		asgState := &state.ASGs[i]
+		if asgState.Finished() || asgState.Failed() || asgState.InstanceRefreshID == "" {
+			continue
+		}
+
+		r, err := s.roller(asgState)
+		if err != nil {
+			return false, err
+		}
+		err = r.Cancel(ctx, asgState)
+		if IsNotCancellable(err) {
+			if state.Phase != PhaseCancelling {
+				message := fmt.Sprintf("The roll of ASG %s can not be cancelled, waiting for it to end.", asgState.Name)
+				s.Scope.Logger.Info(message)
+				s.event(v1.EventTypeWarning, "InstanceRefreshNotCancellable", message)
+			}
+			err = s.inspect(ctx, asgState)
 			if err != nil {
-				s.Scope.Logger.Error(err, "failed to get AWSControlplane")
-				return false
+				return false, err
 			}
-			return key.CancelInstanceRefresh(cp)
+			if !asgState.Finished() && !asgState.Failed() {
+				tracked = append(tracked, asgState.Name)
+			}
+			continue
+		} else if err != nil {
+			return false, err
+		}
+		asgState.Status = autoscaling.InstanceRefreshStatusCancelled
+		s.deleteMetrics(asgState.Name)
+		names = append(names, asgState.Name)
+	}
+	if len(tracked) > 0 {
+		state.Phase = PhaseCancelling
+		return false, nil
+	}
+
+	state.Phase = PhaseCancelled
+	if len(names) == 0 {
+		return true, fmt.Errorf("Cancelled instance refresh")
+	}
+	return true, fmt.Errorf("Cancelled instance refresh for ASG %s", strings.Join(names, ", "))
+}
+
+// Abort cancels all rolls in flight and restores their ASGs before an
+// instance refresh fails for good, as it is not reconciled anymore then. Rolls
+// which can not be cancelled carry on.
+func (s *InstanceRefreshService) Abort(ctx context.Context, state *State) error {
+	for i := range state.ASGs {
+		asgState := &state.ASGs[i]
+		if asgState.Finished() || asgState.InstanceRefreshID == "" {
+			continue
 		}
-		if v, ok := asgFilter[key.MachineDeploymentLabel]; ok {
-			md := &infrastructurev1alpha3.AWSMachineDeployment{}
-			err := s.Client.Get(ctx, types.NamespacedName{Name: v, Namespace: s.Scope.ClusterNamespace()}, md)
+
+		if !asgState.Failed() {
+			r, err := s.roller(asgState)
 			if err != nil {
-				s.Scope.Logger.Error(err, "failed to get AWSMachineDeployment")
-				return false
+				return err
+			}
+			err = r.Cancel(ctx, asgState)
+			if err != nil && !IsNotCancellable(err) {
+				return err
 			}
-			return key.CancelInstanceRefresh(md)
+			if err == nil {
+				asgState.Status = autoscaling.InstanceRefreshStatusCancelled
+			}
+			s.deleteMetrics(asgState.Name)
 		}
-	} else {
-		cluster := &infrastructurev1alpha3.AWSCluster{}
-		err := s.Client.Get(ctx, types.NamespacedName{Name: s.Scope.ClusterName(), Namespace: s.Scope.ClusterNamespace()}, cluster)
+		err := s.restore(ctx, asgState)
 		if err != nil {
-			s.Scope.Logger.Error(err, "failed to get AWSCluster")
-			return false
+			return err
 		}
-		return key.CancelInstanceRefresh(cluster)
 	}
-	return false
+	return nil
 }
@@ -0,0 +1,1895 @@
+package refresh
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/eks"
+	"github.com/aws/aws-sdk-go/service/eks/eksiface"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+	infrastructurev1alpha3 "github.com/giantswarm/apiextensions/v6/pkg/apis/infrastructure/v1alpha3"
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/giantswarm/aws-rolling-node-operator/pkg/aws/scope"
+	"github.com/giantswarm/aws-rolling-node-operator/pkg/aws/services/asg"
+	eksservice "github.com/giantswarm/aws-rolling-node-operator/pkg/aws/services/eks"
+	"github.com/giantswarm/aws-rolling-node-operator/pkg/capi"
+	"github.com/giantswarm/aws-rolling-node-operator/pkg/key"
+	metrics "github.com/giantswarm/aws-rolling-node-operator/pkg/metrics"
+	"github.com/giantswarm/aws-rolling-node-operator/pkg/promql"
+)
+
+type fakeASGClient struct {
+	autoscalingiface.AutoScalingAPI
+
+	groups    []*autoscaling.Group
+	refreshes map[string][]*autoscaling.InstanceRefresh
+	started   []string
+	lastInput *autoscaling.StartInstanceRefreshInput
+	hooks     map[string]*autoscaling.LifecycleHook
+	completed []string
+}
+
+func (c *fakeASGClient) DescribeAutoScalingGroups(input *autoscaling.DescribeAutoScalingGroupsInput) (*autoscaling.DescribeAutoScalingGroupsOutput, error) {
+	if len(input.AutoScalingGroupNames) == 0 {
+		return &autoscaling.DescribeAutoScalingGroupsOutput{AutoScalingGroups: c.groups}, nil
+	}
+	output := &autoscaling.DescribeAutoScalingGroupsOutput{}
+	for _, group := range c.groups {
+		if *group.AutoScalingGroupName == *input.AutoScalingGroupNames[0] {
+			output.AutoScalingGroups = append(output.AutoScalingGroups, group)
+		}
+	}
+	return output, nil
+}
+
+func (c *fakeASGClient) DescribeInstanceRefreshes(input *autoscaling.DescribeInstanceRefreshesInput) (*autoscaling.DescribeInstanceRefreshesOutput, error) {
+	return &autoscaling.DescribeInstanceRefreshesOutput{InstanceRefreshes: c.refreshes[*input.AutoScalingGroupName]}, nil
+}
+
+func (c *fakeASGClient) StartInstanceRefresh(input *autoscaling.StartInstanceRefreshInput) (*autoscaling.StartInstanceRefreshOutput, error) {
+	name := *input.AutoScalingGroupName
+	for _, r := range c.refreshes[name] {
+		if r.EndTime == nil {
+			return nil, awserr.New(autoscaling.ErrCodeInstanceRefreshInProgressFault, "in progress", nil)
+		}
+	}
+	id := fmt.Sprintf("%s-refresh-%d", name, len(c.refreshes[name]))
+	c.started = append(c.started, name)
+	c.lastInput = input
+	c.refreshes[name] = append([]*autoscaling.InstanceRefresh{{
+		InstanceRefreshId: aws.String(id),
+		Status:            aws.String(autoscaling.InstanceRefreshStatusPending),
+	}}, c.refreshes[name]...)
+	return &autoscaling.StartInstanceRefreshOutput{InstanceRefreshId: aws.String(id)}, nil
+}
+
+func (c *fakeASGClient) CancelInstanceRefresh(input *autoscaling.CancelInstanceRefreshInput) (*autoscaling.CancelInstanceRefreshOutput, error) {
+	for _, r := range c.refreshes[*input.AutoScalingGroupName] {
+		if r.EndTime == nil {
+			r.Status = aws.String(autoscaling.InstanceRefreshStatusCancelling)
+			return &autoscaling.CancelInstanceRefreshOutput{InstanceRefreshId: r.InstanceRefreshId}, nil
+		}
+	}
+	return nil, awserr.New(autoscaling.ErrCodeActiveInstanceRefreshNotFoundFault, "not found", nil)
+}
+
+func (c *fakeASGClient) UpdateAutoScalingGroup(input *autoscaling.UpdateAutoScalingGroupInput) (*autoscaling.UpdateAutoScalingGroupOutput, error) {
+	for _, group := range c.groups {
+		if *group.AutoScalingGroupName != *input.AutoScalingGroupName {
+			continue
+		}
+		if input.LaunchTemplate != nil {
+			group.LaunchTemplate = input.LaunchTemplate
+		}
+		if input.MaxSize != nil {
+			group.MaxSize = input.MaxSize
+		}
+		if input.DesiredCapacity != nil {
+			for i := aws.Int64Value(group.DesiredCapacity); i < *input.DesiredCapacity; i++ {
+				group.Instances = append(group.Instances, &autoscaling.Instance{
+					InstanceId:     aws.String(fmt.Sprintf("i-%d", len(group.Instances))),
+					LifecycleState: aws.String(autoscaling.LifecycleStateInService),
+					LaunchTemplate: group.LaunchTemplate,
+				})
+			}
+			group.DesiredCapacity = input.DesiredCapacity
+		}
+	}
+	return &autoscaling.UpdateAutoScalingGroupOutput{}, nil
+}
+
+func (c *fakeASGClient) TerminateInstanceInAutoScalingGroup(input *autoscaling.TerminateInstanceInAutoScalingGroupInput) (*autoscaling.TerminateInstanceInAutoScalingGroupOutput, error) {
+	for _, group := range c.groups {
+		for i, instance := range group.Instances {
+			if *instance.InstanceId == *input.InstanceId {
+				group.Instances = append(group.Instances[:i], group.Instances[i+1:]...)
+				if aws.BoolValue(input.ShouldDecrementDesiredCapacity) {
+					group.DesiredCapacity = aws.Int64(aws.Int64Value(group.DesiredCapacity) - 1)
+				}
+				return &autoscaling.TerminateInstanceInAutoScalingGroupOutput{}, nil
+			}
+		}
+	}
+	return nil, awserr.New("ValidationError", "instance not found", nil)
+}
+
+func (c *fakeASGClient) PutLifecycleHook(input *autoscaling.PutLifecycleHookInput) (*autoscaling.PutLifecycleHookOutput, error) {
+	if c.hooks == nil {
+		c.hooks = map[string]*autoscaling.LifecycleHook{}
+	}
+	c.hooks[*input.AutoScalingGroupName] = &autoscaling.LifecycleHook{
+		AutoScalingGroupName: input.AutoScalingGroupName,
+		LifecycleHookName:    input.LifecycleHookName,
+		HeartbeatTimeout:     input.HeartbeatTimeout,
+	}
+	return &autoscaling.PutLifecycleHookOutput{}, nil
+}
+
+func (c *fakeASGClient) DescribeLifecycleHooks(input *autoscaling.DescribeLifecycleHooksInput) (*autoscaling.DescribeLifecycleHooksOutput, error) {
+	output := &autoscaling.DescribeLifecycleHooksOutput{}
+	if hook, ok := c.hooks[*input.AutoScalingGroupName]; ok {
+		output.LifecycleHooks = []*autoscaling.LifecycleHook{hook}
+	}
+	return output, nil
+}
+
+func (c *fakeASGClient) DeleteLifecycleHook(input *autoscaling.DeleteLifecycleHookInput) (*autoscaling.DeleteLifecycleHookOutput, error) {
+	delete(c.hooks, *input.AutoScalingGroupName)
+	return &autoscaling.DeleteLifecycleHookOutput{}, nil
+}
+
+func (c *fakeASGClient) CompleteLifecycleAction(input *autoscaling.CompleteLifecycleActionInput) (*autoscaling.CompleteLifecycleActionOutput, error) {
+	c.completed = append(c.completed, *input.InstanceId)
+	return &autoscaling.CompleteLifecycleActionOutput{}, nil
+}
+
+func (c *fakeASGClient) setStatus(name, status string) {
+	c.refreshes[name][0].Status = aws.String(status)
+}
+
+func newGroup(name string) *autoscaling.Group {
+	return &autoscaling.Group{
+		AutoScalingGroupName: aws.String(name),
+		LaunchTemplate:       &autoscaling.LaunchTemplateSpecification{LaunchTemplateId: aws.String("lt-1"), Version: aws.String("1")},
+		Instances: []*autoscaling.Instance{
+			{LaunchTemplate: &autoscaling.LaunchTemplateSpecification{LaunchTemplateId: aws.String("lt-1"), Version: aws.String("1")}},
+		},
+	}
+}
+
+func newTestService(t *testing.T, asgClient *fakeASGClient) *InstanceRefreshService {
+	s := runtime.NewScheme()
+	err := infrastructurev1alpha3.AddToScheme(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	md := &infrastructurev1alpha3.AWSMachineDeployment{ObjectMeta: metav1.ObjectMeta{Name: "md"}}
+
+	return &InstanceRefreshService{
+		Client: fake.NewClientBuilder().WithScheme(s).WithObjects(md).Build(),
+		Scope:  &scope.ClusterScope{Logger: logr.Discard()},
+		ASG:    &asg.Service{Client: asgClient},
+	}
+}
+
+func TestRefreshStepsThroughASGs(t *testing.T) {
+	asgClient := &fakeASGClient{
+		groups:    []*autoscaling.Group{newGroup("asg-1"), newGroup("asg-2")},
+		refreshes: map[string][]*autoscaling.InstanceRefresh{},
+	}
+	s := newTestService(t, asgClient)
+	filter := map[string]string{key.MachineDeploymentLabel: "md"}
+	state := &State{Phase: PhasePending}
+
+	done, err := s.Refresh(context.Background(), state, Preferences{}, filter)
+	if err != nil || done {
+		t.Fatalf("expected refresh to be started, got done=%v err=%v", done, err)
+	}
+	if state.Phase != PhaseInProgress || state.ASGs[0].InstanceRefreshID != "asg-1-refresh-0" {
+		t.Fatalf("expected asg-1 to be in progress, got %+v", state)
+	}
+
+	asgClient.setStatus("asg-1", autoscaling.InstanceRefreshStatusInProgress)
+	done, err = s.Refresh(context.Background(), state, Preferences{}, filter)
+	if err != nil || done {
+		t.Fatalf("expected refresh to be in progress, got done=%v err=%v", done, err)
+	}
+	if len(asgClient.started) != 1 {
+		t.Fatalf("expected one started refresh, got %v", asgClient.started)
+	}
+
+	asgClient.setStatus("asg-1", autoscaling.InstanceRefreshStatusSuccessful)
+	done, err = s.Refresh(context.Background(), state, Preferences{}, filter)
+	if err != nil || done {
+		t.Fatalf("expected refresh of asg-2 to be started, got done=%v err=%v", done, err)
+	}
+	if state.ASGs[1].InstanceRefreshID != "asg-2-refresh-0" {
+		t.Fatalf("expected asg-2 to be in progress, got %+v", state)
+	}
+
+	asgClient.setStatus("asg-2", autoscaling.InstanceRefreshStatusSuccessful)
+	done, err = s.Refresh(context.Background(), state, Preferences{}, filter)
+	if err != nil || !done {
+		t.Fatalf("expected refresh to be done, got done=%v err=%v", done, err)
+	}
+	if state.Phase != PhaseSuccessful {
+		t.Fatalf("expected phase %s, got %s", PhaseSuccessful, state.Phase)
+	}
+}
+
+func TestRefreshAdoptsRefreshInProgress(t *testing.T) {
+	asgClient := &fakeASGClient{
+		groups: []*autoscaling.Group{newGroup("asg-1")},
+		refreshes: map[string][]*autoscaling.InstanceRefresh{
+			"asg-1": {{
+				InstanceRefreshId: aws.String("lost"),
+				Status:            aws.String(autoscaling.InstanceRefreshStatusInProgress),
+			}},
+		},
+	}
+	s := newTestService(t, asgClient)
+	state := &State{Phase: PhasePending}
+
+	done, err := s.Refresh(context.Background(), state, Preferences{}, map[string]string{key.MachineDeploymentLabel: "md"})
+	if err != nil || done {
+		t.Fatalf("expected refresh to be in progress, got done=%v err=%v", done, err)
+	}
+	if state.ASGs[0].InstanceRefreshID != "lost" {
+		t.Fatalf("expected in progress refresh to be adopted, got %+v", state.ASGs[0])
+	}
+}
+
+func TestPlanControlPlaneFirst(t *testing.T) {
+	tag := func(k, v string) *autoscaling.TagDescription {
+		return &autoscaling.TagDescription{Key: aws.String(k), Value: aws.String(v)}
+	}
+	asgs := []*autoscaling.Group{
+		{AutoScalingGroupName: aws.String("np-b"), Tags: []*autoscaling.TagDescription{tag(key.MachineDeploymentLabel, "b")}},
+		{AutoScalingGroupName: aws.String("np-a"), Tags: []*autoscaling.TagDescription{tag(key.MachineDeploymentLabel, "a")}},
+		{AutoScalingGroupName: aws.String("np-c"), Tags: []*autoscaling.TagDescription{tag(key.MachineDeploymentLabel, "c")}},
+		{AutoScalingGroupName: aws.String("tccpn"), Tags: []*autoscaling.TagDescription{tag(key.ControlPlaneLabel, "cp")}},
+	}
+
+	s := runtime.NewScheme()
+	err := infrastructurev1alpha3.AddToScheme(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	md := &infrastructurev1alpha3.AWSMachineDeployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "c",
+			Labels:      map[string]string{key.ClusterLabel: "", key.MachineDeploymentLabel: "c"},
+			Annotations: map[string]string{key.InstanceRefreshPriorityAnnotation: "-1"},
+		},
+	}
+	service := &InstanceRefreshService{
+		Client: fake.NewClientBuilder().WithScheme(s).WithObjects(md).Build(),
+		Scope:  &scope.ClusterScope{Logger: logr.Discard()},
+	}
+
+	states := service.plan(context.Background(), asgs)
+
+	expected := []ASGState{
+		{Name: "tccpn", ControlPlane: true, Stage: 0},
+		{Name: "np-c", Stage: 1},
+		{Name: "np-a", Stage: 2},
+		{Name: "np-b", Stage: 2},
+	}
+	for i, e := range expected {
+		if !reflect.DeepEqual(states[i], e) {
+			t.Fatalf("expected %+v at position %d, got %+v", e, i, states[i])
+		}
+	}
+}
+
+func TestRefreshNodePoolsInParallel(t *testing.T) {
+	asgClient := &fakeASGClient{
+		groups:    []*autoscaling.Group{newGroup("np-1"), newGroup("np-2"), newGroup("np-3")},
+		refreshes: map[string][]*autoscaling.InstanceRefresh{},
+	}
+	s := newTestService(t, asgClient)
+	filter := map[string]string{key.MachineDeploymentLabel: "md"}
+	state := &State{
+		Phase: PhasePending,
+		ASGs:  []ASGState{{Name: "cp", ControlPlane: true}, {Name: "np-1", Stage: 1}, {Name: "np-2", Stage: 1}, {Name: "np-3", Stage: 1}},
+	}
+	asgClient.groups = append(asgClient.groups, newGroup("cp"))
+	preferences := Preferences{MaxParallelASGs: 2}
+
+	_, err := s.Refresh(context.Background(), state, preferences, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(asgClient.started) != 1 || asgClient.started[0] != "cp" {
+		t.Fatalf("expected only the control plane to be started, got %v", asgClient.started)
+	}
+
+	asgClient.setStatus("cp", autoscaling.InstanceRefreshStatusSuccessful)
+	_, err = s.Refresh(context.Background(), state, preferences, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(asgClient.started) != 3 {
+		t.Fatalf("expected two node pools to be started, got %v", asgClient.started)
+	}
+
+	asgClient.setStatus("np-1", autoscaling.InstanceRefreshStatusFailed)
+	asgClient.setStatus("np-2", autoscaling.InstanceRefreshStatusInProgress)
+	done, err := s.Refresh(context.Background(), state, preferences, filter)
+	if err != nil || done {
+		t.Fatalf("expected refresh to wait for np-2, got done=%v err=%v", done, err)
+	}
+	if len(asgClient.started) != 3 {
+		t.Fatalf("expected no further node pool to be started after a failure, got %v", asgClient.started)
+	}
+
+	asgClient.setStatus("np-2", autoscaling.InstanceRefreshStatusSuccessful)
+	done, err = s.Refresh(context.Background(), state, preferences, filter)
+	if err == nil || !done {
+		t.Fatalf("expected refresh to fail, got done=%v err=%v", done, err)
+	}
+	if state.Phase != PhaseFailed {
+		t.Fatalf("expected phase %s, got %s", PhaseFailed, state.Phase)
+	}
+	if state.Summary() != "Refreshed 2 of 4 ASGs, skipped 0, failed 1. Replaced 2 instances, skipped 0 up-to-date instances." {
+		t.Fatalf("unexpected summary %q", state.Summary())
+	}
+}
+
+func TestRefreshEmitsCheckpointEvents(t *testing.T) {
+	asgClient := &fakeASGClient{
+		groups:    []*autoscaling.Group{newGroup("asg-1")},
+		refreshes: map[string][]*autoscaling.InstanceRefresh{},
+	}
+	s := newTestService(t, asgClient)
+	recorder := record.NewFakeRecorder(10)
+	s.Recorder = recorder
+	s.Object = &infrastructurev1alpha3.AWSMachineDeployment{}
+	filter := map[string]string{key.MachineDeploymentLabel: "md"}
+	state := &State{Phase: PhasePending}
+
+	_, err := s.Refresh(context.Background(), state, Preferences{}, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := asgClient.refreshes["asg-1"][0]
+	r.Status = aws.String(autoscaling.InstanceRefreshStatusInProgress)
+	r.Preferences = &autoscaling.RefreshPreferences{
+		CheckpointDelay:       aws.Int64(600),
+		CheckpointPercentages: aws.Int64Slice([]int64{20, 50, 100}),
+	}
+	for _, percentage := range []int64{10, 25, 30, 60} {
+		r.PercentageComplete = aws.Int64(percentage)
+		_, err = s.Refresh(context.Background(), state, Preferences{}, filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if state.ASGs[0].Checkpoint != 50 {
+		t.Fatalf("expected checkpoint 50, got %d", state.ASGs[0].Checkpoint)
+	}
+	if len(recorder.Events) != 2 {
+		t.Fatalf("expected two checkpoint events, got %d", len(recorder.Events))
+	}
+	expected := "Normal InstanceRefreshCheckpointReached ASG asg-1 reached checkpoint 20%, waiting 600 seconds before continuing."
+	if event := <-recorder.Events; event != expected {
+		t.Fatalf("expected event %q, got %q", expected, event)
+	}
+}
+
+func TestRefreshHoldsAtCheckpointUntilApproved(t *testing.T) {
+	asgClient := &fakeASGClient{
+		groups:    []*autoscaling.Group{newGroup("asg-1")},
+		refreshes: map[string][]*autoscaling.InstanceRefresh{},
+	}
+	s := newTestService(t, asgClient)
+	filter := map[string]string{key.MachineDeploymentLabel: "md"}
+	state := &State{Phase: PhasePending}
+	preferences := Preferences{
+		CheckpointPercentages: []int64{50, 100},
+		RequireApproval:       true,
+	}
+
+	_, err := s.Refresh(context.Background(), state, preferences, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := asgClient.refreshes["asg-1"][0]
+	r.Status = aws.String(autoscaling.InstanceRefreshStatusInProgress)
+	r.Preferences = asgClient.lastInput.Preferences
+	r.PercentageComplete = aws.Int64(50)
+	_, err = s.Refresh(context.Background(), state, preferences, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Phase != PhaseAwaitingApproval || state.ASGs[0].Status != ASGStatusAwaitingApproval {
+		t.Fatalf("expected refresh to await approval, got %+v", state)
+	}
+
+	// The held instance refresh is still being cancelled.
+	preferences.ApprovedCheckpoint = 50
+	_, err = s.Refresh(context.Background(), state, preferences, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Phase != PhaseAwaitingApproval || len(asgClient.started) != 1 {
+		t.Fatalf("expected refresh to wait for the cancellation, got %+v", state)
+	}
+
+	r.Status = aws.String(autoscaling.InstanceRefreshStatusCancelled)
+	r.EndTime = aws.Time(time.Now())
+	_, err = s.Refresh(context.Background(), state, preferences, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Phase != PhaseInProgress || state.ASGs[0].InstanceRefreshID != "asg-1-refresh-1" {
+		t.Fatalf("expected refresh to be resumed, got %+v", state)
+	}
+	if !*asgClient.lastInput.Preferences.SkipMatching {
+		t.Fatalf("expected resumed refresh to skip matching instances")
+	}
+	if checkpoints := aws.Int64ValueSlice(asgClient.lastInput.Preferences.CheckpointPercentages); len(checkpoints) != 1 || checkpoints[0] != 100 {
+		t.Fatalf("expected remaining checkpoints [100], got %v", checkpoints)
+	}
+}
+
+func TestResumedCheckpoints(t *testing.T) {
+	checkpoints := []int64{25, 50, 75, 100}
+	resumed := resumedCheckpoints(checkpoints, 25)
+	if !reflect.DeepEqual(resumed, []int64{34, 67, 100}) {
+		t.Fatalf("expected checkpoints [34 67 100], got %v", resumed)
+	}
+	for i, c := range resumed {
+		if reached := fromResumed(c, 25); reached != checkpoints[i+1] {
+			t.Fatalf("expected checkpoint %d to be reached at %d, got %d", checkpoints[i+1], c, reached)
+		}
+	}
+	if resumed := resumedCheckpoints([]int64{25, 50}, 50); len(resumed) != 0 {
+		t.Fatalf("expected no checkpoints, got %v", resumed)
+	}
+}
+
+func TestRefreshRollsBackFailedASG(t *testing.T) {
+	asgClient := &fakeASGClient{
+		groups:    []*autoscaling.Group{newGroup("asg-1")},
+		refreshes: map[string][]*autoscaling.InstanceRefresh{},
+	}
+	asgClient.groups[0].LaunchTemplate.Version = aws.String("$Latest")
+	s := newTestService(t, asgClient)
+	recorder := record.NewFakeRecorder(10)
+	s.Recorder = recorder
+	s.Object = &infrastructurev1alpha3.AWSMachineDeployment{}
+	filter := map[string]string{key.MachineDeploymentLabel: "md"}
+	state := &State{Phase: PhasePending}
+	preferences := Preferences{Rollback: true}
+
+	_, err := s.Refresh(context.Background(), state, preferences, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.ASGs[0].PreviousLaunchTemplateVersion != "1" {
+		t.Fatalf("expected previous launch template version 1, got %q", state.ASGs[0].PreviousLaunchTemplateVersion)
+	}
+
+	asgClient.setStatus("asg-1", autoscaling.InstanceRefreshStatusFailed)
+	asgClient.refreshes["asg-1"][0].EndTime = aws.Time(time.Now())
+	done, err := s.Refresh(context.Background(), state, preferences, filter)
+	if err != nil || done {
+		t.Fatalf("expected rollback to be started, got done=%v err=%v", done, err)
+	}
+	if !state.ASGs[0].RollingBack || state.ASGs[0].InstanceRefreshID != "asg-1-refresh-1" {
+		t.Fatalf("expected ASG to be rolled back, got %+v", state.ASGs[0])
+	}
+	if version := *asgClient.lastInput.DesiredConfiguration.LaunchTemplate.Version; version != "1" {
+		t.Fatalf("expected rollback to launch template version 1, got %s", version)
+	}
+
+	asgClient.setStatus("asg-1", autoscaling.InstanceRefreshStatusSuccessful)
+	done, err = s.Refresh(context.Background(), state, preferences, filter)
+	if err == nil || !done {
+		t.Fatalf("expected refresh to fail after rollback, got done=%v err=%v", done, err)
+	}
+	if state.Phase != PhaseFailed || state.ASGs[0].Status != ASGStatusRolledBack {
+		t.Fatalf("expected rolled back ASG, got %+v", state)
+	}
+	expected := []string{
+		"Warning InstanceRefreshFailed Instance refresh of ASG asg-1 did not succeed, Status: Failed. Rolling back to launch template version 1.",
+		"Normal InstanceRefreshRolledBack Rolled back ASG asg-1 to launch template version 1.",
+	}
+	for _, e := range expected {
+		if event := <-recorder.Events; event != e {
+			t.Fatalf("expected event %q, got %q", e, event)
+		}
+	}
+}
+
+func TestRefreshCooldown(t *testing.T) {
+	testCases := []struct {
+		name            string
+		preferences     Preferences
+		expectedStarted bool
+	}{
+		{
+			name:            "refreshed within cooldown",
+			preferences:     Preferences{CooldownSeconds: 1800},
+			expectedStarted: false,
+		},
+		{
+			name:            "cooldown elapsed",
+			preferences:     Preferences{CooldownSeconds: 300},
+			expectedStarted: true,
+		},
+		{
+			name:            "forced",
+			preferences:     Preferences{CooldownSeconds: 1800, Force: true},
+			expectedStarted: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// The most recently ended instance refresh is not the first one.
+			asgClient := &fakeASGClient{
+				groups: []*autoscaling.Group{newGroup("asg-1")},
+				refreshes: map[string][]*autoscaling.InstanceRefresh{
+					"asg-1": {
+						{InstanceRefreshId: aws.String("old"), Status: aws.String(autoscaling.InstanceRefreshStatusSuccessful), EndTime: aws.Time(time.Now().Add(-48 * time.Hour))},
+						{InstanceRefreshId: aws.String("recent"), Status: aws.String(autoscaling.InstanceRefreshStatusSuccessful), EndTime: aws.Time(time.Now().Add(-10 * time.Minute))},
+					},
+				},
+			}
+			s := newTestService(t, asgClient)
+			recorder := record.NewFakeRecorder(10)
+			s.Recorder = recorder
+			s.Object = &infrastructurev1alpha3.AWSMachineDeployment{}
+			state := &State{Phase: PhasePending}
+
+			_, err := s.Refresh(context.Background(), state, tc.preferences, map[string]string{key.MachineDeploymentLabel: "md"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if started := len(asgClient.started) == 1; started != tc.expectedStarted {
+				t.Fatalf("expected started=%v, got %+v", tc.expectedStarted, state)
+			}
+			if !tc.expectedStarted {
+				if state.ASGs[0].Status != ASGStatusSkipped || len(recorder.Events) != 1 {
+					t.Fatalf("expected ASG to be skipped with an event, got %+v", state)
+				}
+				if event := <-recorder.Events; !strings.HasPrefix(event, "Normal InstanceRefreshSkipped ASG asg-1 already refreshed within the cooldown of 1800 seconds") {
+					t.Fatalf("unexpected event %q", event)
+				}
+			}
+		})
+	}
+}
+
+func TestRefreshReportsProgress(t *testing.T) {
+	asgClient := &fakeASGClient{
+		groups:    []*autoscaling.Group{newGroup("asg-1")},
+		refreshes: map[string][]*autoscaling.InstanceRefresh{},
+	}
+	s := newTestService(t, asgClient)
+	recorder := record.NewFakeRecorder(10)
+	s.Recorder = recorder
+	s.Object = &infrastructurev1alpha3.AWSMachineDeployment{}
+	filter := map[string]string{key.MachineDeploymentLabel: "md"}
+	state := &State{Phase: PhasePending}
+
+	_, err := s.Refresh(context.Background(), state, Preferences{}, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := asgClient.refreshes["asg-1"][0]
+	r.Status = aws.String(autoscaling.InstanceRefreshStatusInProgress)
+	r.PercentageComplete = aws.Int64(40)
+	r.InstancesToUpdate = aws.Int64(3)
+	r.StatusReason = aws.String("Waiting for instances to warm up before continuing.")
+	_, err = s.Refresh(context.Background(), state, Preferences{}, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asgState := state.ASGs[0]
+	if asgState.PercentageComplete != 40 || asgState.InstancesRemaining != 3 || asgState.StatusReason != *r.StatusReason {
+		t.Fatalf("expected progress to be recorded, got %+v", asgState)
+	}
+	if len(recorder.Events) != 0 {
+		t.Fatalf("expected no progress event within the first interval, got %d", len(recorder.Events))
+	}
+	if v := testutil.ToFloat64(metrics.InstanceRefreshPercentageComplete.WithLabelValues("", "", "", "", "asg-1")); v != 40 {
+		t.Fatalf("expected percentage complete metric 40, got %v", v)
+	}
+
+	reportedAt := metav1.NewTime(time.Now().Add(-progressEventInterval))
+	state.ASGs[0].ProgressReportedAt = &reportedAt
+	_, err = s.Refresh(context.Background(), state, Preferences{}, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "Normal InstanceRefreshProgress ASG asg-1 is 40% refreshed, 3 instances remaining, Status: InProgress. Waiting for instances to warm up before continuing."
+	if event := <-recorder.Events; event != expected {
+		t.Fatalf("expected event %q, got %q", expected, event)
+	}
+
+	r.Status = aws.String(autoscaling.InstanceRefreshStatusSuccessful)
+	_, err = s.Refresh(context.Background(), state, Preferences{}, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metrics.InstanceRefreshPercentageComplete.DeleteLabelValues("", "", "", "", "asg-1") {
+		t.Fatal("expected metrics of finished ASG to be removed")
+	}
+}
+
+type fakeEKSClient struct {
+	eksiface.EKSAPI
+
+	nodegroup *eks.Nodegroup
+	updates   map[string]*eks.Update
+	err       error
+}
+
+func (c *fakeEKSClient) DescribeNodegroup(input *eks.DescribeNodegroupInput) (*eks.DescribeNodegroupOutput, error) {
+	return &eks.DescribeNodegroupOutput{Nodegroup: c.nodegroup}, nil
+}
+
+func (c *fakeEKSClient) UpdateNodegroupConfig(input *eks.UpdateNodegroupConfigInput) (*eks.UpdateNodegroupConfigOutput, error) {
+	c.nodegroup.UpdateConfig = input.UpdateConfig
+	return &eks.UpdateNodegroupConfigOutput{}, nil
+}
+
+func (c *fakeEKSClient) UpdateNodegroupVersion(input *eks.UpdateNodegroupVersionInput) (*eks.UpdateNodegroupVersionOutput, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	update := &eks.Update{
+		Id:     aws.String(fmt.Sprintf("update-%d", len(c.updates))),
+		Status: aws.String(eks.UpdateStatusInProgress),
+		Type:   aws.String(eks.UpdateTypeVersionUpdate),
+	}
+	c.updates[*update.Id] = update
+	return &eks.UpdateNodegroupVersionOutput{Update: update}, nil
+}
+
+func (c *fakeEKSClient) DescribeUpdate(input *eks.DescribeUpdateInput) (*eks.DescribeUpdateOutput, error) {
+	return &eks.DescribeUpdateOutput{Update: c.updates[*input.UpdateId]}, nil
+}
+
+func TestRefreshUpdatesEKSNodegroup(t *testing.T) {
+	group := newGroup("eks-ng0")
+	group.LaunchTemplate.Version = aws.String("2")
+	group.Tags = []*autoscaling.TagDescription{
+		{Key: aws.String(capi.EKSClusterNameTag), Value: aws.String("eks01")},
+		{Key: aws.String(capi.EKSNodegroupNameTag), Value: aws.String("ng0")},
+	}
+	asgClient := &fakeASGClient{
+		groups:    []*autoscaling.Group{group},
+		refreshes: map[string][]*autoscaling.InstanceRefresh{},
+	}
+	eksClient := &fakeEKSClient{
+		nodegroup: &eks.Nodegroup{
+			ClusterName:   aws.String("eks01"),
+			NodegroupName: aws.String("ng0"),
+			Status:        aws.String(eks.NodegroupStatusActive),
+			UpdateConfig:  &eks.NodegroupUpdateConfig{MaxUnavailable: aws.Int64(1)},
+		},
+		updates: map[string]*eks.Update{},
+	}
+	s := newTestService(t, asgClient)
+	s.EKS = &eksservice.Service{Client: eksClient}
+	filter := map[string]string{capi.EKSNodegroupNameTag: "ng0"}
+	state := &State{Phase: PhasePending}
+	preferences := Preferences{MinHealthyPercentage: 90}
+
+	// The update config gets updated first.
+	_, err := s.Refresh(context.Background(), state, preferences, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.ASGs[0].EKSNodegroupName != "ng0" || state.ASGs[0].InstanceRefreshID != "" {
+		t.Fatalf("expected the node group to be detected but not updated yet, got %+v", state.ASGs[0])
+	}
+	if v := aws.Int64Value(eksClient.nodegroup.UpdateConfig.MaxUnavailablePercentage); v != 10 {
+		t.Fatalf("expected max unavailable percentage 10, got %d", v)
+	}
+
+	_, err = s.Refresh(context.Background(), state, preferences, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.ASGs[0].InstanceRefreshID != "update-0" || len(asgClient.started) != 0 {
+		t.Fatalf("expected the node group to be updated instead of refreshing instances, got %+v", state.ASGs[0])
+	}
+
+	done, err := s.Refresh(context.Background(), state, preferences, filter)
+	if err != nil || done {
+		t.Fatalf("expected update in progress, got done=%v err=%v", done, err)
+	}
+	if state.ASGs[0].InstancesRemaining != 1 || state.ASGs[0].PercentageComplete != 0 {
+		t.Fatalf("expected 1 outdated instance remaining, got %+v", state.ASGs[0])
+	}
+
+	eksClient.updates["update-0"].Status = aws.String(eks.UpdateStatusSuccessful)
+	done, err = s.Refresh(context.Background(), state, preferences, filter)
+	if err != nil || !done || state.Phase != PhaseSuccessful {
+		t.Fatalf("expected success, got done=%v phase=%s err=%v", done, state.Phase, err)
+	}
+}
+
+type fakeSSMClient struct {
+	ssmiface.SSMAPI
+
+	parameters map[string]string
+}
+
+func (c *fakeSSMClient) GetParameter(input *ssm.GetParameterInput) (*ssm.GetParameterOutput, error) {
+	return &ssm.GetParameterOutput{Parameter: &ssm.Parameter{Value: aws.String(c.parameters[*input.Name])}}, nil
+}
+
+func newEKSGroup() *autoscaling.Group {
+	group := newGroup("eks-ng0")
+	group.Tags = []*autoscaling.TagDescription{
+		{Key: aws.String(capi.EKSClusterNameTag), Value: aws.String("eks01")},
+		{Key: aws.String(capi.EKSNodegroupNameTag), Value: aws.String("ng0")},
+	}
+	return group
+}
+
+func TestRefreshSkipsUpToDateEKSNodegroup(t *testing.T) {
+	asgClient := &fakeASGClient{
+		groups:    []*autoscaling.Group{newEKSGroup()},
+		refreshes: map[string][]*autoscaling.InstanceRefresh{},
+	}
+	eksClient := &fakeEKSClient{
+		nodegroup: &eks.Nodegroup{
+			ClusterName:    aws.String("eks01"),
+			NodegroupName:  aws.String("ng0"),
+			Status:         aws.String(eks.NodegroupStatusActive),
+			UpdateConfig:   &eks.NodegroupUpdateConfig{MaxUnavailablePercentage: aws.Int64(10)},
+			AmiType:        aws.String(eks.AMITypesAl2X8664),
+			Version:        aws.String("1.21"),
+			ReleaseVersion: aws.String("1.21.5-20220123"),
+		},
+		updates: map[string]*eks.Update{},
+	}
+	ssmClient := &fakeSSMClient{parameters: map[string]string{
+		"/aws/service/eks/optimized-ami/1.21/amazon-linux-2/recommended/release_version": "1.21.5-20220123",
+	}}
+	s := newTestService(t, asgClient)
+	s.EKS = &eksservice.Service{Client: eksClient, SSM: ssmClient}
+	filter := map[string]string{capi.EKSNodegroupNameTag: "ng0"}
+	preferences := Preferences{MinHealthyPercentage: 90}
+
+	state := &State{Phase: PhasePending}
+	_, err := s.Refresh(context.Background(), state, preferences, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.ASGs[0].Status != ASGStatusSkipped || len(eksClient.updates) != 0 {
+		t.Fatalf("expected the node group running the latest AMI release to be skipped, got %+v", state.ASGs[0])
+	}
+
+	eksClient.nodegroup.ReleaseVersion = aws.String("1.21.5-20220101")
+	eksClient.err = awserr.New(eks.ErrCodeInvalidParameterException, "release version is not valid", nil)
+	state = &State{Phase: PhasePending}
+	done, err := s.Refresh(context.Background(), state, preferences, filter)
+	if !done || err == nil || state.Phase != PhaseFailed || !state.ASGs[0].Failed() {
+		t.Fatalf("expected a refused update to fail the ASG, got done=%v phase=%s err=%v", done, state.Phase, err)
+	}
+
+	eksClient.err = nil
+	eksClient.nodegroup.LaunchTemplate = &eks.LaunchTemplateSpecification{Id: aws.String("lt-1"), Version: aws.String("3")}
+	preferences.LaunchTemplateVersion = "3"
+	state = &State{Phase: PhasePending}
+	_, err = s.Refresh(context.Background(), state, preferences, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.ASGs[0].Status != ASGStatusSkipped || len(eksClient.updates) != 0 {
+		t.Fatalf("expected the node group running the launch template version to be skipped, got %+v", state.ASGs[0])
+	}
+}
+
+func TestRefreshCancelTracksEKSNodegroupUpdate(t *testing.T) {
+	asgClient := &fakeASGClient{
+		groups:    []*autoscaling.Group{newEKSGroup()},
+		refreshes: map[string][]*autoscaling.InstanceRefresh{},
+	}
+	eksClient := &fakeEKSClient{
+		nodegroup: &eks.Nodegroup{
+			ClusterName:   aws.String("eks01"),
+			NodegroupName: aws.String("ng0"),
+			Status:        aws.String(eks.NodegroupStatusActive),
+			UpdateConfig:  &eks.NodegroupUpdateConfig{MaxUnavailablePercentage: aws.Int64(10)},
+		},
+		updates: map[string]*eks.Update{},
+	}
+	s := newTestService(t, asgClient)
+	s.EKS = &eksservice.Service{Client: eksClient}
+	recorder := record.NewFakeRecorder(10)
+	s.Recorder = recorder
+	s.Object = &infrastructurev1alpha3.AWSMachineDeployment{}
+	filter := map[string]string{capi.EKSNodegroupNameTag: "ng0"}
+	state := &State{Phase: PhasePending}
+	preferences := Preferences{MinHealthyPercentage: 90}
+
+	_, err := s.Refresh(context.Background(), state, preferences, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.ASGs[0].InstanceRefreshID != "update-0" {
+		t.Fatalf("expected the node group to be updated, got %+v", state.ASGs[0])
+	}
+
+	preferences.Cancel = true
+	done, err := s.Refresh(context.Background(), state, preferences, filter)
+	if done || err != nil || state.Phase != PhaseCancelling {
+		t.Fatalf("expected the update to be tracked, got done=%v phase=%s err=%v", done, state.Phase, err)
+	}
+	var events []string
+	for len(recorder.Events) > 0 {
+		events = append(events, <-recorder.Events)
+	}
+	if !strings.Contains(strings.Join(events, "\n"), "InstanceRefreshNotCancellable") {
+		t.Fatalf("expected an event about the update which can not be cancelled, got %v", events)
+	}
+
+	eksClient.updates["update-0"].Status = aws.String(eks.UpdateStatusSuccessful)
+	done, err = s.Refresh(context.Background(), state, Preferences{}, filter)
+	if !done || err == nil || state.Phase != PhaseCancelled {
+		t.Fatalf("expected cancellation once the update ended, got done=%v phase=%s err=%v", done, state.Phase, err)
+	}
+	if state.ASGs[0].Status != eks.UpdateStatusSuccessful {
+		t.Fatalf("expected the update to be reported as it ended, got %s", state.ASGs[0].Status)
+	}
+}
+
+type fakeRoller struct {
+	started   []string
+	cancelled []string
+	statuses  map[string]string
+	deferred  map[string]bool
+}
+
+func (r *fakeRoller) Start(ctx context.Context, asgState *ASGState, preferences Preferences, ignoreCooldown bool) error {
+	if r.deferred[asgState.Name] {
+		return nil
+	}
+	r.started = append(r.started, asgState.Name)
+	asgState.InstanceRefreshID = asgState.Name + "-roll"
+	asgState.Status = autoscaling.InstanceRefreshStatusPending
+	return nil
+}
+
+func (r *fakeRoller) Progress(ctx context.Context, asgState *ASGState) error {
+	if status, ok := r.statuses[asgState.Name]; ok {
+		asgState.Status = status
+	}
+	return nil
+}
+
+func (r *fakeRoller) Cancel(ctx context.Context, asgState *ASGState) error {
+	r.cancelled = append(r.cancelled, asgState.Name)
+	return nil
+}
+
+func TestRefreshWithRoller(t *testing.T) {
+	roller := &fakeRoller{statuses: map[string]string{}}
+	s := &InstanceRefreshService{
+		Scope:   &scope.ClusterScope{Logger: logr.Discard()},
+		Rollers: map[string]Roller{"Fake": roller},
+	}
+	state := &State{
+		Phase: PhasePending,
+		ASGs: []ASGState{
+			{Name: "cp", ControlPlane: true, Roller: "Fake"},
+			{Name: "np-1", Stage: 1, Roller: "Fake"},
+			{Name: "np-2", Stage: 1, Roller: "Fake"},
+		},
+	}
+	preferences := Preferences{MaxParallelASGs: 2}
+
+	_, err := s.Refresh(context.Background(), state, preferences, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(roller.started, ",") != "cp" {
+		t.Fatalf("expected the control plane to be rolled first, got %v", roller.started)
+	}
+
+	roller.statuses["cp"] = autoscaling.InstanceRefreshStatusSuccessful
+	_, err = s.Refresh(context.Background(), state, preferences, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(roller.started, ",") != "cp,np-1,np-2" {
+		t.Fatalf("expected both node pools to be rolled in parallel, got %v", roller.started)
+	}
+
+	preferences.Cancel = true
+	done, err := s.Refresh(context.Background(), state, preferences, nil)
+	if !done || err == nil || state.Phase != PhaseCancelled {
+		t.Fatalf("expected cancellation, got done=%v phase=%s err=%v", done, state.Phase, err)
+	}
+	if strings.Join(roller.cancelled, ",") != "np-1,np-2" {
+		t.Fatalf("expected rolls in flight to be cancelled, got %v", roller.cancelled)
+	}
+}
+
+func TestRefreshCountsASGsInFlightBeforeStarting(t *testing.T) {
+	roller := &fakeRoller{statuses: map[string]string{}, deferred: map[string]bool{"cp-1": true}}
+	s := &InstanceRefreshService{
+		Scope:   &scope.ClusterScope{Logger: logr.Discard()},
+		Rollers: map[string]Roller{"Fake": roller},
+	}
+	state := &State{
+		Phase: PhasePending,
+		ASGs: []ASGState{
+			{Name: "cp-1", ControlPlane: true, Roller: "Fake"},
+			{Name: "cp-2", ControlPlane: true, Roller: "Fake"},
+		},
+	}
+
+	// The start of cp-1 gets deferred, e.g. by a PodDisruptionBudget.
+	_, err := s.Refresh(context.Background(), state, Preferences{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(roller.started, ",") != "cp-2" {
+		t.Fatalf("expected cp-2 to be rolled while cp-1 is deferred, got %v", roller.started)
+	}
+
+	delete(roller.deferred, "cp-1")
+	_, err = s.Refresh(context.Background(), state, Preferences{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(roller.started, ",") != "cp-2" {
+		t.Fatalf("expected the control plane to be rolled one ASG at a time, got %v", roller.started)
+	}
+
+	roller.statuses["cp-2"] = autoscaling.InstanceRefreshStatusSuccessful
+	_, err = s.Refresh(context.Background(), state, Preferences{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(roller.started, ",") != "cp-2,cp-1" {
+		t.Fatalf("expected cp-1 to be rolled once cp-2 finished, got %v", roller.started)
+	}
+}
+
+func newNode(name, instanceID string) *v1.Node {
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       v1.NodeSpec{ProviderID: "aws:///eu-west-1a/" + instanceID},
+		Status: v1.NodeStatus{
+			Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}},
+		},
+	}
+}
+
+func TestRefreshSurgesAndDrains(t *testing.T) {
+	asgClient := &fakeASGClient{
+		groups: []*autoscaling.Group{{
+			AutoScalingGroupName: aws.String("asg-1"),
+			DesiredCapacity:      aws.Int64(1),
+			MaxSize:              aws.Int64(1),
+			LaunchTemplate:       &autoscaling.LaunchTemplateSpecification{LaunchTemplateId: aws.String("lt-1"), Version: aws.String("1")},
+			Instances: []*autoscaling.Instance{{
+				InstanceId:     aws.String("i-0"),
+				LifecycleState: aws.String(autoscaling.LifecycleStateInService),
+				LaunchTemplate: &autoscaling.LaunchTemplateSpecification{LaunchTemplateId: aws.String("lt-1"), Version: aws.String("1")},
+			}},
+		}},
+		refreshes: map[string][]*autoscaling.InstanceRefresh{},
+	}
+	k8sClient := k8sfake.NewSimpleClientset(
+		newNode("node-0", "i-0"),
+		&v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+			Spec:       v1.PodSpec{NodeName: "node-0"},
+		},
+	)
+	// The fake clientset does not implement evictions, evicted pods are
+	// deleted right away.
+	k8sClient.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+		eviction := action.(k8stesting.CreateAction).GetObject().(*policyv1.Eviction)
+		err := k8sClient.Tracker().Delete(v1.SchemeGroupVersion.WithResource("pods"), eviction.Namespace, eviction.Name)
+		return true, nil, err
+	})
+	s := newTestService(t, asgClient)
+	s.WorkloadCluster = func(ctx context.Context) (kubernetes.Interface, error) {
+		return k8sClient, nil
+	}
+	filter := map[string]string{key.MachineDeploymentLabel: "md"}
+	state := &State{Phase: PhasePending}
+	preferences := Preferences{Roller: RollerSurge, Surge: 1, LaunchTemplateVersion: "2"}
+	group := asgClient.groups[0]
+
+	refresh := func() bool {
+		t.Helper()
+		done, err := s.Refresh(context.Background(), state, preferences, filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return done
+	}
+
+	refresh()
+	if state.ASGs[0].InstanceRefreshID == "" || aws.StringValue(group.LaunchTemplate.Version) != "2" {
+		t.Fatalf("expected the launch template to be updated, got %+v", state.ASGs[0])
+	}
+	if len(asgClient.started) != 0 {
+		t.Fatalf("expected no instance refresh, got %v", asgClient.started)
+	}
+
+	// A new instance is launched ahead of draining the outdated one.
+	refresh()
+	if aws.Int64Value(group.DesiredCapacity) != 2 || aws.Int64Value(group.MaxSize) != 2 {
+		t.Fatalf("expected desired capacity and max size 2, got %d and %d", aws.Int64Value(group.DesiredCapacity), aws.Int64Value(group.MaxSize))
+	}
+
+	// The outdated node is not drained before the new one is Ready.
+	refresh()
+	node, _ := k8sClient.CoreV1().Nodes().Get(context.Background(), "node-0", metav1.GetOptions{})
+	if node.Spec.Unschedulable {
+		t.Fatal("expected node-0 not to be cordoned before node-1 is Ready")
+	}
+
+	_, err := k8sClient.CoreV1().Nodes().Create(context.Background(), newNode("node-1", "i-1"), metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	refresh()
+	node, _ = k8sClient.CoreV1().Nodes().Get(context.Background(), "node-0", metav1.GetOptions{})
+	if !node.Spec.Unschedulable {
+		t.Fatal("expected node-0 to be cordoned")
+	}
+	pods, _ := k8sClient.CoreV1().Pods("default").List(context.Background(), metav1.ListOptions{})
+	if len(pods.Items) != 0 {
+		t.Fatalf("expected the pod to be evicted, got %d pods", len(pods.Items))
+	}
+
+	refresh()
+	if len(group.Instances) != 1 || aws.StringValue(group.Instances[0].InstanceId) != "i-1" || aws.Int64Value(group.DesiredCapacity) != 1 {
+		t.Fatalf("expected i-0 to be terminated, got %+v", group)
+	}
+
+	if !refresh() || state.Phase != PhaseSuccessful {
+		t.Fatalf("expected success, got %+v", state)
+	}
+	if aws.Int64Value(group.MaxSize) != 1 {
+		t.Fatalf("expected max size to be restored, got %d", aws.Int64Value(group.MaxSize))
+	}
+}
+
+// newSurgeGroup returns an ASG of a single instance i-0 running launch
+// template version 1.
+func newSurgeGroup() *autoscaling.Group {
+	return &autoscaling.Group{
+		AutoScalingGroupName: aws.String("asg-1"),
+		DesiredCapacity:      aws.Int64(1),
+		MaxSize:              aws.Int64(1),
+		LaunchTemplate:       &autoscaling.LaunchTemplateSpecification{LaunchTemplateId: aws.String("lt-1"), Version: aws.String("1")},
+		Instances: []*autoscaling.Instance{{
+			InstanceId:     aws.String("i-0"),
+			LifecycleState: aws.String(autoscaling.LifecycleStateInService),
+			LaunchTemplate: &autoscaling.LaunchTemplateSpecification{LaunchTemplateId: aws.String("lt-1"), Version: aws.String("1")},
+		}},
+	}
+}
+
+type fakeEC2Client struct {
+	ec2iface.EC2API
+
+	latest int64
+}
+
+func (c *fakeEC2Client) DescribeLaunchTemplates(input *ec2.DescribeLaunchTemplatesInput) (*ec2.DescribeLaunchTemplatesOutput, error) {
+	return &ec2.DescribeLaunchTemplatesOutput{LaunchTemplates: []*ec2.LaunchTemplate{{
+		LaunchTemplateId:     aws.String("lt-1"),
+		LatestVersionNumber:  aws.Int64(c.latest),
+		DefaultVersionNumber: aws.Int64(1),
+	}}}, nil
+}
+
+func TestRefreshSurgeStart(t *testing.T) {
+	group := newSurgeGroup()
+	asgClient := &fakeASGClient{
+		groups:    []*autoscaling.Group{group},
+		refreshes: map[string][]*autoscaling.InstanceRefresh{},
+	}
+	s := newTestService(t, asgClient)
+	s.ASG.EC2 = &fakeEC2Client{latest: 2}
+	filter := map[string]string{key.MachineDeploymentLabel: "md"}
+	preferences := Preferences{Roller: RollerSurge, Surge: 1, SkipMatching: true}
+
+	// Without kubeconfig the ASG fails before it got touched.
+	state := &State{Phase: PhasePending}
+	done, err := s.Refresh(context.Background(), state, preferences, filter)
+	if !done || err == nil || !state.ASGs[0].Failed() {
+		t.Fatalf("expected the ASG to fail, got done=%v err=%v state=%+v", done, err, state.ASGs[0])
+	}
+	if v := aws.StringValue(group.LaunchTemplate.Version); v != "1" || state.ASGs[0].ConfiguredLaunchTemplateVersion != "" {
+		t.Fatalf("expected the launch template to be left alone, got %s", v)
+	}
+
+	// Instances running the latest version are skipped.
+	group.DesiredCapacity = aws.Int64(2)
+	group.MaxSize = aws.Int64(2)
+	group.Instances = append(group.Instances, &autoscaling.Instance{
+		InstanceId:     aws.String("i-1"),
+		LifecycleState: aws.String(autoscaling.LifecycleStateInService),
+		LaunchTemplate: &autoscaling.LaunchTemplateSpecification{LaunchTemplateId: aws.String("lt-1"), Version: aws.String("2")},
+	})
+	s.WorkloadCluster = func(ctx context.Context) (kubernetes.Interface, error) {
+		return k8sfake.NewSimpleClientset(newNode("node-0", "i-0"), newNode("node-1", "i-1")), nil
+	}
+	state = &State{Phase: PhasePending}
+	_, err = s.Refresh(context.Background(), state, preferences, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(state.ASGs[0].InstancesToReplace, ",") != "i-0" {
+		t.Fatalf("expected only i-0 to be replaced, got %v", state.ASGs[0].InstancesToReplace)
+	}
+}
+
+func TestRefreshSurgeCancelRestoresLaunchTemplate(t *testing.T) {
+	asgClient := &fakeASGClient{
+		groups:    []*autoscaling.Group{newSurgeGroup()},
+		refreshes: map[string][]*autoscaling.InstanceRefresh{},
+	}
+	k8sClient := k8sfake.NewSimpleClientset(newNode("node-0", "i-0"))
+	s := newTestService(t, asgClient)
+	s.WorkloadCluster = func(ctx context.Context) (kubernetes.Interface, error) {
+		return k8sClient, nil
+	}
+	recorder := record.NewFakeRecorder(10)
+	s.Recorder = recorder
+	s.Object = &infrastructurev1alpha3.AWSMachineDeployment{}
+	filter := map[string]string{key.MachineDeploymentLabel: "md"}
+	state := &State{Phase: PhasePending}
+	preferences := Preferences{Roller: RollerSurge, Surge: 1}
+	group := asgClient.groups[0]
+
+	_, err := s.Refresh(context.Background(), state, preferences, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aws.StringValue(group.LaunchTemplate.Version) != key.DefaultLaunchTemplateVersion || state.ASGs[0].ConfiguredLaunchTemplateVersion != "1" {
+		t.Fatalf("expected launch template version 1 to be recorded, got %+v", state.ASGs[0])
+	}
+
+	// Surging again from a state which did not get recorded does not raise
+	// the desired capacity twice.
+	stale := State{Phase: state.Phase, ASGs: append([]ASGState{}, state.ASGs...)}
+	_, err = s.Refresh(context.Background(), state, preferences, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Refresh(context.Background(), &stale, preferences, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aws.Int64Value(group.DesiredCapacity) != 2 || len(group.Instances) != 2 {
+		t.Fatalf("expected desired capacity 2, got %d", aws.Int64Value(group.DesiredCapacity))
+	}
+
+	preferences.Cancel = true
+	done, err := s.Refresh(context.Background(), state, preferences, filter)
+	if !done || err == nil || state.ASGs[0].Status != autoscaling.InstanceRefreshStatusCancelled {
+		t.Fatalf("expected the instance refresh to be cancelled, got done=%v err=%v state=%+v", done, err, state.ASGs[0])
+	}
+	if aws.StringValue(group.LaunchTemplate.Version) != "1" {
+		t.Fatalf("expected launch template version 1 to be restored, got %s", aws.StringValue(group.LaunchTemplate.Version))
+	}
+	expected := "Stopped replacing instances, ASG asg-1 keeps 1 surged instances beyond its desired capacity of 1 and its maximum size raised from 1 to 2. Scale it in by hand once its nodes are drained."
+	if state.ASGs[0].StatusReason != expected {
+		t.Fatalf("expected status reason %q, got %q", expected, state.ASGs[0].StatusReason)
+	}
+	if event := <-recorder.Events; event != "Warning SurgeCapacityLeft "+expected {
+		t.Fatalf("expected the leftover capacity to be reported, got %q", event)
+	}
+}
+
+func TestAbortCancelsRollsInFlight(t *testing.T) {
+	asgClient := &fakeASGClient{
+		groups:    []*autoscaling.Group{newGroup("asg-1")},
+		refreshes: map[string][]*autoscaling.InstanceRefresh{},
+	}
+	s := newTestService(t, asgClient)
+	s.WorkloadCluster = func(ctx context.Context) (kubernetes.Interface, error) {
+		return k8sfake.NewSimpleClientset(), nil
+	}
+	filter := map[string]string{key.MachineDeploymentLabel: "md"}
+	state := &State{Phase: PhasePending}
+
+	_, err := s.Refresh(context.Background(), state, Preferences{Drain: true, DrainTimeoutSeconds: 300}, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Abort(context.Background(), state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.ASGs[0].Status != autoscaling.InstanceRefreshStatusCancelled {
+		t.Fatalf("expected the instance refresh to be cancelled, got %s", state.ASGs[0].Status)
+	}
+	if _, ok := asgClient.hooks["asg-1"]; ok {
+		t.Fatal("expected the drain lifecycle hook to be deleted")
+	}
+
+	asgClient = &fakeASGClient{
+		groups:    []*autoscaling.Group{newSurgeGroup()},
+		refreshes: map[string][]*autoscaling.InstanceRefresh{},
+	}
+	s = newTestService(t, asgClient)
+	s.WorkloadCluster = func(ctx context.Context) (kubernetes.Interface, error) {
+		return k8sfake.NewSimpleClientset(newNode("node-0", "i-0")), nil
+	}
+	state = &State{Phase: PhasePending}
+
+	_, err = s.Refresh(context.Background(), state, Preferences{Roller: RollerSurge, Surge: 1}, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Abort(context.Background(), state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := aws.StringValue(asgClient.groups[0].LaunchTemplate.Version); v != "1" || state.ASGs[0].Status != autoscaling.InstanceRefreshStatusCancelled {
+		t.Fatalf("expected launch template version 1 to be restored, got %s and %+v", v, state.ASGs[0])
+	}
+}
+
+func TestRefreshSurgeDrainTimesOut(t *testing.T) {
+	asgClient := &fakeASGClient{
+		groups:    []*autoscaling.Group{newSurgeGroup()},
+		refreshes: map[string][]*autoscaling.InstanceRefresh{},
+	}
+	k8sClient := k8sfake.NewSimpleClientset(
+		newNode("node-0", "i-0"),
+		newNode("node-1", "i-1"),
+		&v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+			Spec:       v1.PodSpec{NodeName: "node-0"},
+		},
+	)
+	// A PodDisruptionBudget refuses every eviction.
+	k8sClient.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+		return true, nil, apierrors.NewTooManyRequests("disruption budget", 0)
+	})
+	s := newTestService(t, asgClient)
+	s.WorkloadCluster = func(ctx context.Context) (kubernetes.Interface, error) {
+		return k8sClient, nil
+	}
+	recorder := record.NewFakeRecorder(10)
+	s.Recorder = recorder
+	s.Object = &infrastructurev1alpha3.AWSMachineDeployment{}
+	filter := map[string]string{key.MachineDeploymentLabel: "md"}
+	state := &State{Phase: PhasePending}
+	preferences := Preferences{Roller: RollerSurge, Surge: 1, DrainTimeoutSeconds: 300}
+	group := asgClient.groups[0]
+
+	for i := 0; i < 3; i++ {
+		_, err := s.Refresh(context.Background(), state, preferences, filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(group.Instances) != 2 || !strings.Contains(state.ASGs[0].StatusReason, "default/app") {
+		t.Fatalf("expected the termination to wait for the blocked pod, got %+v", state.ASGs[0])
+	}
+
+	// The termination continues once the drain timed out.
+	state.ASGs[0].DrainingSince["i-0"] = metav1.NewTime(time.Now().Add(-time.Hour))
+	_, err := s.Refresh(context.Background(), state, preferences, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(group.Instances) != 1 || aws.StringValue(group.Instances[0].InstanceId) != "i-1" {
+		t.Fatalf("expected i-0 to be terminated, got %+v", group.Instances)
+	}
+	var events []string
+	for len(recorder.Events) > 0 {
+		events = append(events, <-recorder.Events)
+	}
+	expected := "Warning DrainTimedOut Draining node node-0 of instance i-0 in ASG asg-1 timed out after 5m0s with 1 pods left, terminating it anyway."
+	if len(events) == 0 || events[len(events)-1] != expected {
+		t.Fatalf("expected event %q, got %v", expected, events)
+	}
+}
+
+func TestRefreshDrainsTerminatingInstances(t *testing.T) {
+	group := newGroup("asg-1")
+	group.Instances[0].InstanceId = aws.String("i-0")
+	asgClient := &fakeASGClient{
+		groups:    []*autoscaling.Group{group},
+		refreshes: map[string][]*autoscaling.InstanceRefresh{},
+	}
+	k8sClient := k8sfake.NewSimpleClientset(
+		newNode("node-0", "i-0"),
+		&v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+			Spec:       v1.PodSpec{NodeName: "node-0"},
+		},
+	)
+	// A PodDisruptionBudget refuses every eviction.
+	k8sClient.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+		return true, nil, apierrors.NewTooManyRequests("disruption budget", 0)
+	})
+	s := newTestService(t, asgClient)
+	s.WorkloadCluster = func(ctx context.Context) (kubernetes.Interface, error) {
+		return k8sClient, nil
+	}
+	filter := map[string]string{key.MachineDeploymentLabel: "md"}
+	state := &State{Phase: PhasePending}
+	preferences := Preferences{Drain: true, DrainTimeoutSeconds: 300}
+
+	_, err := s.Refresh(context.Background(), state, preferences, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hook := asgClient.hooks["asg-1"]; hook == nil || aws.Int64Value(hook.HeartbeatTimeout) != 300 {
+		t.Fatalf("expected a lifecycle hook with a heartbeat timeout of 300 seconds, got %+v", hook)
+	}
+
+	asgClient.setStatus("asg-1", autoscaling.InstanceRefreshStatusInProgress)
+	group.Instances[0].LifecycleState = aws.String(lifecycleStateTerminatingWait)
+	_, err = s.Refresh(context.Background(), state, preferences, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, _ := k8sClient.CoreV1().Nodes().Get(context.Background(), "node-0", metav1.GetOptions{})
+	if !node.Spec.Unschedulable {
+		t.Fatal("expected node-0 to be cordoned")
+	}
+	if len(asgClient.completed) != 0 || !strings.Contains(state.ASGs[0].StatusReason, "default/app") {
+		t.Fatalf("expected the termination to wait for the blocked pod, got %+v", state.ASGs[0])
+	}
+
+	// The termination continues once the drain timed out.
+	state.ASGs[0].DrainingSince["i-0"] = metav1.NewTime(time.Now().Add(-time.Hour))
+	_, err = s.Refresh(context.Background(), state, preferences, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(asgClient.completed, ",") != "i-0" {
+		t.Fatalf("expected the lifecycle action of i-0 to be completed, got %v", asgClient.completed)
+	}
+
+	asgClient.setStatus("asg-1", autoscaling.InstanceRefreshStatusSuccessful)
+	done, err := s.Refresh(context.Background(), state, preferences, filter)
+	if err != nil || !done {
+		t.Fatalf("expected refresh to be done, got done=%v err=%v", done, err)
+	}
+	if _, ok := asgClient.hooks["asg-1"]; ok {
+		t.Fatal("expected the lifecycle hook to be deleted")
+	}
+}
+
+func TestRefreshDrainCleansUp(t *testing.T) {
+	group := newGroup("asg-1")
+	group.Instances[0].InstanceId = aws.String("i-0")
+	asgClient := &fakeASGClient{
+		groups:    []*autoscaling.Group{group},
+		refreshes: map[string][]*autoscaling.InstanceRefresh{},
+	}
+	s := newTestService(t, asgClient)
+	filter := map[string]string{key.MachineDeploymentLabel: "md"}
+	preferences := Preferences{Drain: true, DrainTimeoutSeconds: 300}
+
+	// Without kubeconfig the ASG fails before it got touched.
+	state := &State{Phase: PhasePending}
+	done, err := s.Refresh(context.Background(), state, preferences, filter)
+	if !done || err == nil || !state.ASGs[0].Failed() {
+		t.Fatalf("expected the ASG to fail, got done=%v err=%v state=%+v", done, err, state.ASGs[0])
+	}
+	if _, ok := asgClient.hooks["asg-1"]; ok || len(asgClient.started) != 0 {
+		t.Fatal("expected neither a lifecycle hook nor an instance refresh")
+	}
+
+	reachable := true
+	s.WorkloadCluster = func(ctx context.Context) (kubernetes.Interface, error) {
+		if !reachable {
+			return nil, fmt.Errorf("connection refused")
+		}
+		return k8sfake.NewSimpleClientset(newNode("node-0", "i-0")), nil
+	}
+	state = &State{Phase: PhasePending}
+	_, err = s.Refresh(context.Background(), state, preferences, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The drain start times survive the workload cluster being unreachable.
+	since := metav1.NewTime(time.Now().Add(-time.Minute).Truncate(time.Second))
+	state.ASGs[0].DrainingSince = map[string]metav1.Time{"i-0": since}
+	asgClient.setStatus("asg-1", autoscaling.InstanceRefreshStatusInProgress)
+	group.Instances[0].LifecycleState = aws.String(lifecycleStateTerminatingWait)
+	reachable = false
+	s.workloadClient = nil
+	_, err = s.Refresh(context.Background(), state, preferences, filter)
+	if !IsTransient(err) {
+		t.Fatalf("expected a transient error, got %v", err)
+	}
+	if state.ASGs[0].DrainingSince["i-0"] != since {
+		t.Fatalf("expected the drain of i-0 to have started at %s, got %v", since, state.ASGs[0].DrainingSince)
+	}
+
+	// Failed ASGs get their lifecycle hook removed.
+	state.ASGs[0].Status = ASGStatusUnhealthy
+	_, err = s.Refresh(context.Background(), state, preferences, filter)
+	if err == nil {
+		t.Fatal("expected the instance refresh to fail")
+	}
+	if _, ok := asgClient.hooks["asg-1"]; ok {
+		t.Fatal("expected the lifecycle hook to be deleted")
+	}
+}
+
+func TestRefreshDelaysOnBlockingPodDisruptionBudget(t *testing.T) {
+	group := newGroup("asg-1")
+	group.Instances[0].InstanceId = aws.String("i-0")
+	asgClient := &fakeASGClient{
+		groups:    []*autoscaling.Group{group},
+		refreshes: map[string][]*autoscaling.InstanceRefresh{},
+	}
+	pdb := &policyv1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+		Spec: policyv1.PodDisruptionBudgetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "app"}},
+		},
+	}
+	k8sClient := k8sfake.NewSimpleClientset(
+		newNode("node-0", "i-0"),
+		&v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", Labels: map[string]string{"app": "app"}},
+			Spec:       v1.PodSpec{NodeName: "node-0"},
+		},
+		pdb,
+	)
+	s := newTestService(t, asgClient)
+	s.WorkloadCluster = func(ctx context.Context) (kubernetes.Interface, error) {
+		return k8sClient, nil
+	}
+	filter := map[string]string{key.MachineDeploymentLabel: "md"}
+	state := &State{Phase: PhasePending}
+	preferences := Preferences{CheckPDBs: true, CheckPDBsTimeoutSeconds: 600}
+
+	done, err := s.Refresh(context.Background(), state, preferences, filter)
+	if err != nil || done {
+		t.Fatalf("expected refresh to be delayed, got done=%v err=%v", done, err)
+	}
+	if len(asgClient.started) != 0 || !strings.Contains(state.ASGs[0].StatusReason, "default/app") {
+		t.Fatalf("expected the instance refresh to be delayed by default/app, got %+v", state.ASGs[0])
+	}
+
+	pdb.Status.DisruptionsAllowed = 1
+	_, err = k8sClient.PolicyV1().PodDisruptionBudgets("default").UpdateStatus(context.Background(), pdb, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Refresh(context.Background(), state, preferences, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(asgClient.started, ",") != "asg-1" {
+		t.Fatalf("expected the instance refresh to be started, got %v", asgClient.started)
+	}
+}
+
+func TestRefreshFailsOnBlockingPodDisruptionBudgetTimeout(t *testing.T) {
+	group := newGroup("asg-1")
+	group.Instances[0].InstanceId = aws.String("i-0")
+	asgClient := &fakeASGClient{
+		groups:    []*autoscaling.Group{group},
+		refreshes: map[string][]*autoscaling.InstanceRefresh{},
+	}
+	k8sClient := k8sfake.NewSimpleClientset(
+		newNode("node-0", "i-0"),
+		&v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", Labels: map[string]string{"app": "app"}},
+			Spec:       v1.PodSpec{NodeName: "node-0"},
+		},
+		&policyv1.PodDisruptionBudget{
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+			Spec: policyv1.PodDisruptionBudgetSpec{
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "app"}},
+			},
+		},
+	)
+	s := newTestService(t, asgClient)
+	s.WorkloadCluster = func(ctx context.Context) (kubernetes.Interface, error) {
+		return k8sClient, nil
+	}
+	recorder := record.NewFakeRecorder(10)
+	s.Recorder = recorder
+	s.Object = &infrastructurev1alpha3.AWSMachineDeployment{}
+	filter := map[string]string{key.MachineDeploymentLabel: "md"}
+	state := &State{Phase: PhasePending}
+	preferences := Preferences{CheckPDBs: true, CheckPDBsTimeoutSeconds: 600}
+
+	done, err := s.Refresh(context.Background(), state, preferences, filter)
+	if err != nil || done || state.ASGs[0].BlockedSince == nil {
+		t.Fatalf("expected refresh to be delayed, got done=%v err=%v state=%+v", done, err, state.ASGs[0])
+	}
+
+	blockedSince := metav1.NewTime(time.Now().Add(-time.Hour))
+	state.ASGs[0].BlockedSince = &blockedSince
+	done, err = s.Refresh(context.Background(), state, preferences, filter)
+	if !done || err == nil || state.Phase != PhaseFailed || state.ASGs[0].Status != autoscaling.InstanceRefreshStatusFailed {
+		t.Fatalf("expected the ASG to fail, got done=%v err=%v state=%+v", done, err, state)
+	}
+	if len(asgClient.started) != 0 {
+		t.Fatalf("expected no instance refresh, got %v", asgClient.started)
+	}
+	var events []string
+	for len(recorder.Events) > 0 {
+		events = append(events, <-recorder.Events)
+	}
+	expected := "Warning InstanceRefreshBlockedTimedOut PodDisruptionBudgets default/app allowed no disruptions of pods on the nodes of ASG asg-1 for 10m0s, giving up."
+	if len(events) != 2 || events[1] != expected {
+		t.Fatalf("expected event %q, got %v", expected, events)
+	}
+}
+
+func TestRefreshWaitsForHealthyWorkloadCluster(t *testing.T) {
+	var groups []*autoscaling.Group
+	for _, name := range []string{"asg-1", "asg-2"} {
+		group := newGroup(name)
+		group.Instances[0].InstanceId = aws.String("i-" + name)
+		group.Instances[0].LifecycleState = aws.String(autoscaling.LifecycleStateInService)
+		groups = append(groups, group)
+	}
+	asgClient := &fakeASGClient{
+		groups:    groups,
+		refreshes: map[string][]*autoscaling.InstanceRefresh{},
+	}
+	node := newNode("node-1", "i-asg-1")
+	node.Status.Conditions[0].Status = v1.ConditionFalse
+	daemonSet := &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "cni", Namespace: metav1.NamespaceSystem},
+		Status:     appsv1.DaemonSetStatus{DesiredNumberScheduled: 2, UpdatedNumberScheduled: 2, NumberAvailable: 2},
+	}
+	k8sClient := k8sfake.NewSimpleClientset(node, newNode("node-2", "i-asg-2"), daemonSet)
+	s := newTestService(t, asgClient)
+	s.WorkloadCluster = func(ctx context.Context) (kubernetes.Interface, error) {
+		return k8sClient, nil
+	}
+	filter := map[string]string{key.MachineDeploymentLabel: "md"}
+	state := &State{Phase: PhasePending}
+	preferences := Preferences{HealthCheck: true, HealthCheckTimeoutSeconds: 600}
+
+	refresh := func() (bool, error) {
+		t.Helper()
+		return s.Refresh(context.Background(), state, preferences, filter)
+	}
+
+	_, err := refresh()
+	if err != nil {
+		t.Fatal(err)
+	}
+	asgClient.setStatus("asg-1", autoscaling.InstanceRefreshStatusSuccessful)
+	_, err = refresh()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.ASGs[0].Status != ASGStatusVerifying || len(asgClient.started) != 1 {
+		t.Fatalf("expected asg-2 to wait for asg-1 to be verified, got %+v", state.ASGs[0])
+	}
+	if !strings.Contains(state.ASGs[0].StatusReason, "i-asg-1") {
+		t.Fatalf("expected the NotReady node to be reported, got %q", state.ASGs[0].StatusReason)
+	}
+
+	node.Status.Conditions[0].Status = v1.ConditionTrue
+	_, err = k8sClient.CoreV1().Nodes().UpdateStatus(context.Background(), node, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = refresh()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.ASGs[0].Status != autoscaling.InstanceRefreshStatusSuccessful || strings.Join(asgClient.started, ",") != "asg-1,asg-2" {
+		t.Fatalf("expected asg-2 to be started once asg-1 is healthy, got %+v", state.ASGs[0])
+	}
+
+	// A DaemonSet which does not roll out stops the instance refresh once
+	// the health check timed out.
+	daemonSet.Status.NumberAvailable = 1
+	_, err = k8sClient.AppsV1().DaemonSets(metav1.NamespaceSystem).UpdateStatus(context.Background(), daemonSet, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	asgClient.setStatus("asg-2", autoscaling.InstanceRefreshStatusSuccessful)
+	_, err = refresh()
+	if err != nil {
+		t.Fatal(err)
+	}
+	past := metav1.NewTime(time.Now().Add(-time.Hour))
+	state.ASGs[1].VerifyingSince = &past
+	done, err := refresh()
+	if !done || err == nil || state.Phase != PhaseFailed || state.ASGs[1].Status != ASGStatusUnhealthy {
+		t.Fatalf("expected the instance refresh to fail, got done=%v err=%v state=%+v", done, err, state)
+	}
+	if !strings.Contains(state.ASGs[1].StatusReason, "cni") {
+		t.Fatalf("expected the DaemonSet to be reported, got %q", state.ASGs[1].StatusReason)
+	}
+}
+
+func TestRefreshCancelsOnFailingHealthQuery(t *testing.T) {
+	healthy := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("query") != "slo:error_rate < 0.01" {
+			t.Errorf("unexpected query %q", r.FormValue("query"))
+		}
+		result := `[]`
+		if healthy {
+			result = `[{"metric":{},"value":[1650000000,"0.001"]}]`
+		}
+		fmt.Fprintf(w, `{"status":"success","data":{"resultType":"vector","result":%s}}`, result)
+	}))
+	defer server.Close()
+
+	asgClient := &fakeASGClient{
+		groups:    []*autoscaling.Group{newGroup("asg-1"), newGroup("asg-2")},
+		refreshes: map[string][]*autoscaling.InstanceRefresh{},
+	}
+	s := newTestService(t, asgClient)
+	prometheus, err := promql.New(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Prometheus = prometheus
+	recorder := record.NewFakeRecorder(10)
+	s.Recorder = recorder
+	s.Object = &infrastructurev1alpha3.AWSMachineDeployment{}
+	filter := map[string]string{key.MachineDeploymentLabel: "md"}
+	state := &State{Phase: PhasePending}
+	preferences := Preferences{HealthQuery: "slo:error_rate < 0.01"}
+
+	_, err = s.Refresh(context.Background(), state, preferences, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(asgClient.started, ",") != "asg-1" {
+		t.Fatalf("expected asg-1 to be started while the query is true, got %v", asgClient.started)
+	}
+
+	// The query turns false by the time the first checkpoint is reached.
+	healthy = false
+	r := asgClient.refreshes["asg-1"][0]
+	r.Status = aws.String(autoscaling.InstanceRefreshStatusInProgress)
+	r.Preferences = &autoscaling.RefreshPreferences{CheckpointPercentages: aws.Int64Slice([]int64{50, 100})}
+	r.PercentageComplete = aws.Int64(50)
+	done, err := s.Refresh(context.Background(), state, preferences, filter)
+	if !done || err == nil || state.Phase != PhaseCancelled {
+		t.Fatalf("expected the instance refresh to be cancelled, got done=%v phase=%s err=%v", done, state.Phase, err)
+	}
+	if aws.StringValue(r.Status) != autoscaling.InstanceRefreshStatusCancelling || len(asgClient.started) != 1 {
+		t.Fatalf("expected the instance refresh of asg-1 to be cancelled, got %s", aws.StringValue(r.Status))
+	}
+
+	var events []string
+	for len(recorder.Events) > 0 {
+		events = append(events, <-recorder.Events)
+	}
+	expected := `Warning InstanceRefreshHealthQueryFailed Health query "slo:error_rate < 0.01" evaluated to false at checkpoint 50% of ASG asg-1, cancelling the instance refresh.`
+	if events[len(events)-1] != expected {
+		t.Fatalf("expected event %q, got %v", expected, events)
+	}
+}
+
+// closedWindow returns a maintenance window which opens the day after
+// tomorrow, so it is closed for the duration of a test.
+func closedWindow() string {
+	return fmt.Sprintf("%s 00:00-01:00", time.Now().UTC().Add(48 * time.Hour).Weekday().String()[:3])
+}
+
+func TestRefreshQueuesOutsideMaintenanceWindow(t *testing.T) {
+	asgClient := &fakeASGClient{
+		groups:    []*autoscaling.Group{newGroup("asg-1"), newGroup("asg-2")},
+		refreshes: map[string][]*autoscaling.InstanceRefresh{},
+	}
+	s := newTestService(t, asgClient)
+	recorder := record.NewFakeRecorder(10)
+	s.Recorder = recorder
+	s.Object = &infrastructurev1alpha3.AWSMachineDeployment{}
+	filter := map[string]string{key.MachineDeploymentLabel: "md"}
+	state := &State{Phase: PhasePending}
+	preferences := Preferences{MaintenanceWindow: closedWindow(), MaintenanceWindowPolicy: MaintenanceWindowPolicyFinish}
+
+	for i := 0; i < 2; i++ {
+		done, err := s.Refresh(context.Background(), state, preferences, filter)
+		if done || err != nil || state.Phase != PhaseQueued || len(asgClient.started) != 0 {
+			t.Fatalf("expected the instance refresh to be queued, got done=%v phase=%s err=%v started=%v", done, state.Phase, err, asgClient.started)
+		}
+	}
+	if len(recorder.Events) != 1 || !strings.HasPrefix(<-recorder.Events, "Normal InstanceRefreshQueued Maintenance window") {
+		t.Fatalf("expected a single queued event")
+	}
+
+	preferences.MaintenanceWindow = "* 00:00-24:00"
+	_, err := s.Refresh(context.Background(), state, preferences, filter)
+	if err != nil || state.Phase != PhaseInProgress || strings.Join(asgClient.started, ",") != "asg-1" {
+		t.Fatalf("expected asg-1 to be started once the window opened, got phase=%s err=%v started=%v", state.Phase, err, asgClient.started)
+	}
+
+	// The window closes while asg-1 is in flight, it is finished and asg-2
+	// is queued.
+	preferences.MaintenanceWindow = closedWindow()
+	asgClient.setStatus("asg-1", autoscaling.InstanceRefreshStatusInProgress)
+	_, err = s.Refresh(context.Background(), state, preferences, filter)
+	if err != nil || state.Phase != PhaseInProgress {
+		t.Fatalf("expected asg-1 to go on, got phase=%s err=%v", state.Phase, err)
+	}
+	asgClient.setStatus("asg-1", autoscaling.InstanceRefreshStatusSuccessful)
+	done, err := s.Refresh(context.Background(), state, preferences, filter)
+	if done || err != nil || state.Phase != PhaseQueued || len(asgClient.started) != 1 {
+		t.Fatalf("expected asg-2 to be queued, got done=%v phase=%s err=%v started=%v", done, state.Phase, err, asgClient.started)
+	}
+}
+
+func TestRefreshCancelsWhenMaintenanceWindowCloses(t *testing.T) {
+	asgClient := &fakeASGClient{
+		groups:    []*autoscaling.Group{newGroup("asg-1")},
+		refreshes: map[string][]*autoscaling.InstanceRefresh{},
+	}
+	s := newTestService(t, asgClient)
+	recorder := record.NewFakeRecorder(10)
+	s.Recorder = recorder
+	s.Object = &infrastructurev1alpha3.AWSMachineDeployment{}
+	filter := map[string]string{key.MachineDeploymentLabel: "md"}
+	state := &State{Phase: PhasePending}
+	preferences := Preferences{MaintenanceWindow: "* 00:00-24:00", MaintenanceWindowPolicy: MaintenanceWindowPolicyCancel}
+
+	_, err := s.Refresh(context.Background(), state, preferences, filter)
+	if err != nil || state.Phase != PhaseInProgress {
+		t.Fatalf("expected asg-1 to be started, got phase=%s err=%v", state.Phase, err)
+	}
+
+	preferences.MaintenanceWindow = closedWindow()
+	asgClient.setStatus("asg-1", autoscaling.InstanceRefreshStatusInProgress)
+	done, err := s.Refresh(context.Background(), state, preferences, filter)
+	if !done || err == nil || state.Phase != PhaseCancelled {
+		t.Fatalf("expected the instance refresh to be cancelled, got done=%v phase=%s err=%v", done, state.Phase, err)
+	}
+	if status := aws.StringValue(asgClient.refreshes["asg-1"][0].Status); status != autoscaling.InstanceRefreshStatusCancelling {
+		t.Fatalf("expected the instance refresh of asg-1 to be cancelled, got %s", status)
+	}
+	if len(recorder.Events) != 1 || !strings.HasPrefix(<-recorder.Events, "Warning MaintenanceWindowClosed") {
+		t.Fatalf("expected a maintenance window closed event")
+	}
+}
+
+func TestSummaryCountsSkippedInstances(t *testing.T) {
+	state := &State{
+		ASGs: []ASGState{
+			{Name: "asg-1", Status: autoscaling.InstanceRefreshStatusSuccessful, Instances: 5},
+			{Name: "asg-2", Status: autoscaling.InstanceRefreshStatusSuccessful, Instances: 4, InstancesToUpdate: 1, SkipMatching: true},
+			{Name: "asg-3", Status: ASGStatusSkipped},
+		},
+	}
+
+	expected := "Refreshed 2 of 3 ASGs, skipped 1, failed 0. Replaced 6 instances, skipped 3 up-to-date instances."
+	if state.Summary() != expected {
+		t.Fatalf("expected summary %q, got %q", expected, state.Summary())
+	}
+}
+
+func TestDesiredConfiguration(t *testing.T) {
+	testCases := []struct {
+		name          string
+		asg           *autoscaling.Group
+		version       string
+		expected      *autoscaling.LaunchTemplateSpecification
+		expectedMixed *autoscaling.MixedInstancesPolicy
+		expectError   bool
+	}{
+		{
+			name:     "launch template id",
+			asg:      newGroup("asg-1"),
+			version:  "$Default",
+			expected: &autoscaling.LaunchTemplateSpecification{LaunchTemplateId: aws.String("lt-1"), Version: aws.String("$Default")},
+		},
+		{
+			name: "launch template name without instances",
+			asg: &autoscaling.Group{
+				AutoScalingGroupName: aws.String("asg-1"),
+				LaunchTemplate:       &autoscaling.LaunchTemplateSpecification{LaunchTemplateName: aws.String("lt"), Version: aws.String("3")},
+			},
+			version:  "",
+			expected: &autoscaling.LaunchTemplateSpecification{LaunchTemplateName: aws.String("lt"), Version: aws.String("$Latest")},
+		},
+		{
+			name: "mixed instances policy",
+			asg: &autoscaling.Group{
+				AutoScalingGroupName: aws.String("asg-1"),
+				MixedInstancesPolicy: &autoscaling.MixedInstancesPolicy{
+					InstancesDistribution: &autoscaling.InstancesDistribution{OnDemandPercentageAboveBaseCapacity: aws.Int64(0)},
+					LaunchTemplate: &autoscaling.LaunchTemplate{
+						LaunchTemplateSpecification: &autoscaling.LaunchTemplateSpecification{LaunchTemplateId: aws.String("lt-1"), Version: aws.String("3")},
+						Overrides:                   []*autoscaling.LaunchTemplateOverrides{{InstanceType: aws.String("m5.xlarge")}, {InstanceType: aws.String("m5a.xlarge")}},
+					},
+				},
+			},
+			version: "4",
+			expectedMixed: &autoscaling.MixedInstancesPolicy{
+				InstancesDistribution: &autoscaling.InstancesDistribution{OnDemandPercentageAboveBaseCapacity: aws.Int64(0)},
+				LaunchTemplate: &autoscaling.LaunchTemplate{
+					LaunchTemplateSpecification: &autoscaling.LaunchTemplateSpecification{LaunchTemplateId: aws.String("lt-1"), Version: aws.String("4")},
+					Overrides:                   []*autoscaling.LaunchTemplateOverrides{{InstanceType: aws.String("m5.xlarge")}, {InstanceType: aws.String("m5a.xlarge")}},
+				},
+			},
+		},
+		{
+			name:        "launch configuration",
+			asg:         &autoscaling.Group{AutoScalingGroupName: aws.String("asg-1"), LaunchConfigurationName: aws.String("lc")},
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			desired, err := desiredConfiguration(tc.asg, tc.version)
+			if tc.expectError {
+				if err == nil {
+					t.Fatalf("expected error, got %v", desired)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.expectedMixed != nil {
+				if desired.LaunchTemplate != nil || desired.MixedInstancesPolicy.String() != tc.expectedMixed.String() {
+					t.Fatalf("expected %v, got %v", tc.expectedMixed, desired)
+				}
+				return
+			}
+			if desired.LaunchTemplate.String() != tc.expected.String() {
+				t.Fatalf("expected %v, got %v", tc.expected, desired.LaunchTemplate)
+			}
+		})
+	}
+}
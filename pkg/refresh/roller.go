@@ -0,0 +1,99 @@
+package refresh
+
+import (
+	"context"
+	"fmt"
+)
+
+// Rollers replace the instances of ASGs.
+const (
+	// RollerInstanceRefresh replaces instances with an instance refresh of
+	// the ASG.
+	RollerInstanceRefresh = "InstanceRefresh"
+	// RollerEKSNodegroup replaces instances by updating the EKS managed node
+	// group the ASG belongs to.
+	RollerEKSNodegroup = "EKSNodegroup"
+	// RollerSurge replaces instances by launching new ones ahead of draining
+	// the nodes of the outdated ones in the workload cluster.
+	RollerSurge = "Surge"
+)
+
+// Roller is a mechanism replacing the instances of a single ASG. Refresh
+// orchestrates the ASGs and only talks to AWS through rollers, which makes
+// adding mechanisms and testing the orchestration possible without AWS.
+type Roller interface {
+	// Start starts replacing the instances of the given ASG and records the
+	// ID of the roll in InstanceRefreshID. It leaves the ID unset to be
+	// called again later, e.g. while the ASG is busy, or marks the ASG as
+	// skipped if there is nothing to roll. The cooldown is not applied when
+	// resuming or rolling back, which is what ignoreCooldown is for.
+	Start(ctx context.Context, asgState *ASGState, preferences Preferences, ignoreCooldown bool) error
+	// Progress updates the status and progress of the roll of the given
+	// ASG.
+	Progress(ctx context.Context, asgState *ASGState) error
+	// Cancel stops the roll of the given ASG in flight. Setting the status
+	// is left to the caller. Rollers whose rolls can not be cancelled return
+	// an error asserted by IsNotCancellable, the roll is then tracked until
+	// it ended.
+	Cancel(ctx context.Context, asgState *ASGState) error
+}
+
+// Restorer is implemented by rollers which change the configuration of the ASG
+// themselves, so they can restore it once the roll of the ASG did not
+// succeed.
+type Restorer interface {
+	// Restore restores the configuration of the given failed ASG. It is
+	// called on every reconciliation of the failed ASG and has to be
+	// idempotent.
+	Restore(ctx context.Context, asgState *ASGState) error
+}
+
+// rollers returns the rollers by name, defaulting to the ones backed by AWS.
+func (s *InstanceRefreshService) rollers() map[string]Roller {
+	if s.Rollers == nil {
+		s.Rollers = map[string]Roller{
+			RollerInstanceRefresh: &instanceRefreshRoller{s},
+			RollerEKSNodegroup:    &nodegroupRoller{s},
+			RollerSurge:           &surgeRoller{s},
+		}
+	}
+	return s.Rollers
+}
+
+// roller returns the roller of the given ASG. ASGs planned without a roller,
+// e.g. by a previous version of the operator, get one detected.
+func (s *InstanceRefreshService) roller(asgState *ASGState) (Roller, error) {
+	name := asgState.Roller
+	if name == "" {
+		name = detectRoller(asgState)
+	}
+	r, ok := s.rollers()[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown roller %q for ASG %s", name, asgState.Name)
+	}
+	return r, nil
+}
+
+// restore restores the configuration of the given failed ASG if its roller
+// changed it.
+func (s *InstanceRefreshService) restore(ctx context.Context, asgState *ASGState) error {
+	r, err := s.roller(asgState)
+	if err != nil {
+		return err
+	}
+	restorer, ok := r.(Restorer)
+	if !ok {
+		return nil
+	}
+	return restorer.Restore(ctx, asgState)
+}
+
+// detectRoller returns the roller for the given ASG if none got requested.
+// ASGs of EKS managed node groups are rolled by updating the node group, all
+// others by an instance refresh.
+func detectRoller(asgState *ASGState) string {
+	if asgState.EKSNodegroupName != "" {
+		return RollerEKSNodegroup
+	}
+	return RollerInstanceRefresh
+}
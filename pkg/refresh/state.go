@@ -0,0 +1,209 @@
+package refresh
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Phase describes where an instance refresh is in its lifecycle.
+type Phase string
+
+const (
+	PhasePending    Phase = "Pending"
+	PhaseInProgress Phase = "InProgress"
+	PhaseSuccessful Phase = "Successful"
+	PhaseCancelled  Phase = "Cancelled"
+	PhaseFailed     Phase = "Failed"
+	// PhaseAwaitingApproval means at least one ASG is held at a checkpoint.
+	PhaseAwaitingApproval Phase = "AwaitingApproval"
+	// PhaseQueued means the remaining ASGs wait for the maintenance window
+	// to open.
+	PhaseQueued Phase = "Queued"
+	// PhaseCancelling means the instance refresh got cancelled and waits for
+	// rolls which can not be cancelled to end.
+	PhaseCancelling Phase = "Cancelling"
+)
+
+// ASG statuses set by the operator. All other ASG statuses are the ones
+// reported by AWS.
+const (
+	// ASGStatusSkipped marks an ASG which was not refreshed, e.g. because it
+	// has no instances.
+	ASGStatusSkipped = "Skipped"
+	// ASGStatusAwaitingApproval marks an ASG whose instance refresh got
+	// cancelled at a checkpoint until the checkpoint gets approved.
+	ASGStatusAwaitingApproval = "AwaitingApproval"
+	// ASGStatusRolledBack marks an ASG whose instance refresh failed and
+	// whose instances got rolled back to the previous launch template version.
+	ASGStatusRolledBack = "RolledBack"
+	// ASGStatusRollbackFailed marks an ASG whose instance refresh failed and
+	// whose rollback failed as well.
+	ASGStatusRollbackFailed = "RollbackFailed"
+	// ASGStatusVerifying marks an ASG whose instances got replaced while the
+	// health of the workload cluster is checked.
+	ASGStatusVerifying = "Verifying"
+	// ASGStatusUnhealthy marks an ASG after whose instance refresh the
+	// workload cluster did not become healthy in time.
+	ASGStatusUnhealthy = "Unhealthy"
+)
+
+// ASGState is the progress of the instance refresh of a single ASG.
+type ASGState struct {
+	Name              string `json:"name"`
+	ControlPlane      bool   `json:"controlPlane,omitempty"`
+	Stage             int    `json:"stage,omitempty"`
+	InstanceRefreshID string `json:"instanceRefreshID,omitempty"`
+	Status            string `json:"status,omitempty"`
+	Checkpoint        int64  `json:"checkpoint,omitempty"`
+	// ResumedAt is the checkpoint the instance refresh in progress got
+	// resumed at after its approval. AWS reports the checkpoints and progress
+	// of a resumed instance refresh relative to the instances left at that
+	// checkpoint.
+	ResumedAt         int64 `json:"resumedAt,omitempty"`
+	SkipMatching      bool  `json:"skipMatching,omitempty"`
+	Instances         int64 `json:"instances,omitempty"`
+	InstancesToUpdate int64 `json:"instancesToUpdate,omitempty"`
+	// PreviousLaunchTemplateVersion is the launch template version the
+	// instances ran before the instance refresh started.
+	PreviousLaunchTemplateVersion string `json:"previousLaunchTemplateVersion,omitempty"`
+	// RollingBack is set once the instance refresh failed and the instances
+	// are being rolled back to the previous launch template version.
+	RollingBack bool `json:"rollingBack,omitempty"`
+	// PercentageComplete is the progress of the instance refresh as reported
+	// by AWS.
+	PercentageComplete int64 `json:"percentageComplete,omitempty"`
+	// StatusReason is the reason AWS gives for the status of the instance
+	// refresh, e.g. why it does not make progress.
+	StatusReason string `json:"statusReason,omitempty"`
+	// InstancesRemaining is the number of instances AWS still has to update.
+	InstancesRemaining int64 `json:"instancesRemaining,omitempty"`
+	// ProgressReportedAt is the time the progress of the instance refresh
+	// was last reported as an event.
+	ProgressReportedAt *metav1.Time `json:"progressReportedAt,omitempty"`
+	// EKSClusterName and EKSNodegroupName are set for ASGs of EKS managed
+	// node groups.
+	EKSClusterName   string `json:"eksClusterName,omitempty"`
+	EKSNodegroupName string `json:"eksNodegroupName,omitempty"`
+	// Roller is the name of the roller replacing the instances of the ASG.
+	Roller string `json:"roller,omitempty"`
+	// Surge is the number of instances the Surge roller launches ahead of
+	// draining and terminating as many outdated ones.
+	Surge int64 `json:"surge,omitempty"`
+	// InstancesToReplace are the outdated instances the Surge roller still
+	// has to terminate, DrainingInstances the ones currently drained.
+	InstancesToReplace []string `json:"instancesToReplace,omitempty"`
+	DrainingInstances  []string `json:"drainingInstances,omitempty"`
+	// DrainingSince are the times the instances held by the lifecycle hook
+	// of an instance refresh started being drained, by instance ID.
+	DrainingSince map[string]metav1.Time `json:"drainingSince,omitempty"`
+	// DesiredCapacity, MaxSize and ConfiguredLaunchTemplateVersion are the
+	// ones of the ASG before the Surge roller started. The launch template
+	// version configured on the ASG is restored if its roll does not
+	// succeed.
+	DesiredCapacity                 int64  `json:"desiredCapacity,omitempty"`
+	MaxSize                         int64  `json:"maxSize,omitempty"`
+	ConfiguredLaunchTemplateVersion string `json:"configuredLaunchTemplateVersion,omitempty"`
+	// DrainTimeoutSeconds is the time after which the Surge roller
+	// terminates instances whose nodes did not finish draining.
+	DrainTimeoutSeconds int64 `json:"drainTimeoutSeconds,omitempty"`
+	// VerifyingSince is the time the health check of the workload cluster
+	// started after the instances of the ASG got replaced.
+	VerifyingSince *metav1.Time `json:"verifyingSince,omitempty"`
+	// BlockedSince is the time PodDisruptionBudgets started delaying the
+	// instance refresh of the ASG.
+	BlockedSince *metav1.Time `json:"blockedSince,omitempty"`
+}
+
+// Finished returns true if there is nothing left to do for the ASG.
+func (a ASGState) Finished() bool {
+	return a.Status == ASGStatusSkipped || a.Status == autoscaling.InstanceRefreshStatusSuccessful
+}
+
+// ReplacedInstances returns the number of instances replaced by the instance
+// refresh of a finished ASG.
+func (a ASGState) ReplacedInstances() int64 {
+	if a.Status != autoscaling.InstanceRefreshStatusSuccessful {
+		return 0
+	}
+	if !a.SkipMatching || a.InstancesToUpdate > a.Instances {
+		return a.Instances
+	}
+	return a.InstancesToUpdate
+}
+
+// SkippedInstances returns the number of instances of a finished ASG which
+// already matched the desired configuration and were not replaced.
+func (a ASGState) SkippedInstances() int64 {
+	if a.Status != autoscaling.InstanceRefreshStatusSuccessful {
+		return 0
+	}
+	return a.Instances - a.ReplacedInstances()
+}
+
+// Failed returns true if the instance refresh of the ASG failed or got
+// cancelled, regardless of whether it got rolled back.
+func (a ASGState) Failed() bool {
+	switch a.Status {
+	case autoscaling.InstanceRefreshStatusFailed, autoscaling.InstanceRefreshStatusCancelled,
+		ASGStatusRolledBack, ASGStatusRollbackFailed, ASGStatusUnhealthy:
+		return true
+	}
+	return false
+}
+
+// State is the progress of an instance refresh across all ASGs it covers. It
+// is persisted in the status of the InstanceRefresh between reconciliations.
+type State struct {
+	Phase Phase      `json:"phase"`
+	ASGs  []ASGState `json:"asgs"`
+}
+
+// Remaining returns true if there are ASGs which still need to be refreshed.
+func (s *State) Remaining() bool {
+	for _, a := range s.ASGs {
+		if !a.Finished() && !a.Failed() {
+			return true
+		}
+	}
+	return false
+}
+
+// PercentageComplete returns the average progress of all ASGs. Finished ASGs
+// count as complete.
+func (s *State) PercentageComplete() int64 {
+	if len(s.ASGs) == 0 {
+		return 0
+	}
+	var total int64
+	for _, a := range s.ASGs {
+		if a.Finished() {
+			total += 100
+		} else {
+			total += a.PercentageComplete
+		}
+	}
+	return total / int64(len(s.ASGs))
+}
+
+// Summary aggregates the outcome of all ASGs into a single human readable
+// sentence.
+func (s *State) Summary() string {
+	var refreshed, skipped, failed int
+	var replacedInstances, skippedInstances int64
+	for _, a := range s.ASGs {
+		replacedInstances += a.ReplacedInstances()
+		skippedInstances += a.SkippedInstances()
+		switch {
+		case a.Status == ASGStatusSkipped:
+			skipped++
+		case a.Finished():
+			refreshed++
+		case a.Failed():
+			failed++
+		}
+	}
+	return fmt.Sprintf("Refreshed %d of %d ASGs, skipped %d, failed %d. Replaced %d instances, skipped %d up-to-date instances.",
+		refreshed, len(s.ASGs), skipped, failed, replacedInstances, skippedInstances)
+}
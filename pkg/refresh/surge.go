@@ -0,0 +1,460 @@
+package refresh
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/giantswarm/microerror"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/giantswarm/aws-rolling-node-operator/pkg/key"
+	"github.com/giantswarm/aws-rolling-node-operator/pkg/workload"
+)
+
+// surgeRoller replaces the instances of an ASG itself, so their nodes get
+// drained gracefully. In batches of Surge instances, it raises the desired
+// capacity of the ASG, waits for the new nodes to be Ready in the workload
+// cluster, cordons and drains the nodes of as many outdated instances through
+// the eviction API, which respects PodDisruptionBudgets, and terminates the
+// instances while decrementing the desired capacity again.
+type surgeRoller struct {
+	s *InstanceRefreshService
+}
+
+// Start configures the ASG to launch instances of the desired launch template
+// version and records the outdated instances. Instances already running the
+// version are kept if SkipMatching is set, $Latest and $Default are resolved
+// to version numbers for that. The cooldown does not apply, the Surge roller
+// keeps no history. The ASG fails before it got touched if the workload
+// cluster has no kubeconfig. The launch template version configured on the
+// ASG before is recorded, so it can be restored if the roll does not succeed.
+func (r *surgeRoller) Start(ctx context.Context, asgState *ASGState, preferences Preferences, ignoreCooldown bool) error {
+	asg, err := r.describe(asgState)
+	if err != nil {
+		return err
+	}
+	if asg == nil {
+		r.s.skip(asgState, fmt.Sprintf("ASG %s does not exist anymore, skipping...", asgState.Name))
+		return nil
+	}
+	if len(asg.Instances) == 0 {
+		r.s.skip(asgState, fmt.Sprintf("ASG %s has no instances, skipping...", asgState.Name))
+		return nil
+	}
+	desired, err := desiredConfiguration(asg, preferences.LaunchTemplateVersion)
+	if err != nil {
+		r.s.skip(asgState, fmt.Sprintf("%s, skipping...", err))
+		return nil
+	}
+
+	version := preferences.LaunchTemplateVersion
+	if preferences.SkipMatching {
+		version, err = r.s.launchTemplateVersionNumber(asg, version)
+		if err != nil {
+			return err
+		}
+	}
+	var outdated []string
+	for _, instance := range asg.Instances {
+		if preferences.SkipMatching && instance.LaunchTemplate != nil &&
+			aws.StringValue(instance.LaunchTemplate.Version) == version {
+			continue
+		}
+		outdated = append(outdated, aws.StringValue(instance.InstanceId))
+	}
+	if len(outdated) == 0 {
+		r.s.skip(asgState, fmt.Sprintf("All instances of ASG %s already run launch template version %s, skipping...", asgState.Name, version))
+		return nil
+	}
+
+	ok, err := r.s.requireWorkloadCluster(ctx, asgState)
+	if err != nil || !ok {
+		return err
+	}
+
+	if asgState.ConfiguredLaunchTemplateVersion == "" {
+		asgState.ConfiguredLaunchTemplateVersion = configuredLaunchTemplateVersion(asg)
+	}
+	_, err = r.s.ASG.Client.UpdateAutoScalingGroup(&autoscaling.UpdateAutoScalingGroupInput{
+		AutoScalingGroupName: asg.AutoScalingGroupName,
+		LaunchTemplate:       desired.LaunchTemplate,
+		MixedInstancesPolicy: desired.MixedInstancesPolicy,
+	})
+	if err != nil {
+		r.s.Scope.Logger.Error(err, "failed to update autoscaling group")
+		return err
+	}
+
+	if asgState.PreviousLaunchTemplateVersion == "" {
+		asgState.PreviousLaunchTemplateVersion = launchTemplateVersionInPlace(asg)
+	}
+	asgState.DrainTimeoutSeconds = preferences.DrainTimeoutSeconds
+	asgState.Instances = int64(len(asg.Instances))
+	asgState.SkipMatching = preferences.SkipMatching
+	asgState.Surge = preferences.Surge
+	if asgState.Surge < 1 {
+		asgState.Surge = 1
+	}
+	asgState.InstancesToReplace = outdated
+	asgState.DrainingInstances = nil
+	asgState.InstancesToUpdate = int64(len(outdated))
+	asgState.InstancesRemaining = int64(len(outdated))
+	asgState.PercentageComplete = 0
+	asgState.DesiredCapacity = aws.Int64Value(asg.DesiredCapacity)
+	asgState.MaxSize = aws.Int64Value(asg.MaxSize)
+
+	r.s.Scope.Logger.Info(fmt.Sprintf("Started replacing %d instances in ASG %s, %d at a time", len(outdated), asgState.Name, asgState.Surge))
+	asgState.InstanceRefreshID = fmt.Sprintf("surge-%d", time.Now().Unix())
+	asgState.Status = autoscaling.InstanceRefreshStatusInProgress
+	return nil
+}
+
+// Progress advances the replacement of the instances of the given ASG by a
+// single step and never waits for the workload cluster.
+func (r *surgeRoller) Progress(ctx context.Context, asgState *ASGState) error {
+	asg, err := r.describe(asgState)
+	if err != nil {
+		return err
+	}
+	if asg == nil {
+		asgState.Status = autoscaling.InstanceRefreshStatusFailed
+		asgState.StatusReason = fmt.Sprintf("ASG %s does not exist anymore.", asgState.Name)
+		return nil
+	}
+
+	// Instances which got terminated, by us or anyone else, need no
+	// replacement anymore.
+	running := map[string]*autoscaling.Instance{}
+	for _, instance := range asg.Instances {
+		if !strings.HasPrefix(aws.StringValue(instance.LifecycleState), "Terminating") {
+			running[aws.StringValue(instance.InstanceId)] = instance
+		}
+	}
+	asgState.InstancesToReplace = filterInstances(asgState.InstancesToReplace, running)
+	asgState.DrainingInstances = filterInstances(asgState.DrainingInstances, running)
+	defer r.updateProgress(asgState)
+
+	if len(asgState.DrainingInstances) == 0 {
+		if len(asgState.InstancesToReplace) == 0 {
+			return r.finish(asgState, asg)
+		}
+		return r.surge(asgState, asg)
+	}
+
+	// The ASG is surged already, so the roll waits for the workload cluster
+	// even if its kubeconfig is gone.
+	k8sClient, err := r.s.workloadCluster(ctx)
+	if IsTransient(err) {
+		return err
+	} else if err != nil {
+		return microerror.Maskf(transientError, "failed to reach the workload cluster of ASG %s: %s", asgState.Name, err)
+	}
+	nodes, err := workload.NodesByInstanceID(ctx, k8sClient)
+	if err != nil {
+		return microerror.Maskf(transientError, "failed to list nodes of ASG %s: %s", asgState.Name, err)
+	}
+
+	// The new instances have to be Ready before the outdated ones get
+	// drained.
+	if int64(len(running)) < aws.Int64Value(asg.DesiredCapacity) {
+		asgState.StatusReason = fmt.Sprintf("Waiting for %d instances to launch.", aws.Int64Value(asg.DesiredCapacity)-int64(len(running)))
+		return nil
+	}
+	outdated := map[string]bool{}
+	for _, id := range asgState.InstancesToReplace {
+		outdated[id] = true
+	}
+	for id, instance := range running {
+		if outdated[id] {
+			continue
+		}
+		node, ok := nodes[id]
+		if aws.StringValue(instance.LifecycleState) != autoscaling.LifecycleStateInService || !ok || !workload.Ready(node) {
+			asgState.StatusReason = fmt.Sprintf("Waiting for the node of instance %s to become Ready.", id)
+			return nil
+		}
+	}
+
+	timeout := time.Duration(asgState.DrainTimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = time.Duration(key.DefaultDrainTimeoutSeconds) * time.Second
+	}
+	draining := map[string]metav1.Time{}
+	for _, id := range asgState.DrainingInstances {
+		since, ok := asgState.DrainingSince[id]
+		if !ok {
+			since = metav1.Now()
+		}
+		draining[id] = since
+	}
+
+	pending := map[string]metav1.Time{}
+	var blocked, drained []string
+	for _, id := range asgState.DrainingInstances {
+		since := draining[id]
+		if node, ok := nodes[id]; ok {
+			err = workload.Cordon(ctx, k8sClient, node)
+			if err != nil {
+				return microerror.Maskf(transientError, "failed to cordon node %s: %s", node.Name, err)
+			}
+			status, err := workload.Drain(ctx, k8sClient, node.Name)
+			if err != nil {
+				return microerror.Maskf(transientError, "failed to drain node %s: %s", node.Name, err)
+			}
+			if status.Remaining > 0 && time.Since(since.Time) < timeout {
+				blocked = append(blocked, status.Blocked...)
+				pending[id] = since
+				continue
+			}
+			if status.Remaining > 0 {
+				message := fmt.Sprintf("Draining node %s of instance %s in ASG %s timed out after %s with %d pods left, terminating it anyway.",
+					node.Name, id, asgState.Name, timeout, status.Remaining)
+				r.s.Scope.Logger.Info(message)
+				r.s.event(v1.EventTypeWarning, "DrainTimedOut", message)
+			}
+		}
+
+		_, err = r.s.ASG.Client.TerminateInstanceInAutoScalingGroup(&autoscaling.TerminateInstanceInAutoScalingGroupInput{
+			InstanceId:                     aws.String(id),
+			ShouldDecrementDesiredCapacity: aws.Bool(true),
+		})
+		if err != nil {
+			r.s.Scope.Logger.Error(err, "failed to terminate instance")
+			return err
+		}
+		r.s.Scope.Logger.Info(fmt.Sprintf("Drained and terminated instance %s of ASG %s", id, asgState.Name))
+		drained = append(drained, id)
+	}
+	asgState.DrainingSince = nil
+	if len(pending) > 0 {
+		asgState.DrainingSince = pending
+	}
+	done := map[string]*autoscaling.Instance{}
+	for _, id := range drained {
+		done[id] = nil
+	}
+	asgState.InstancesToReplace = excludeInstances(asgState.InstancesToReplace, done)
+	asgState.DrainingInstances = excludeInstances(asgState.DrainingInstances, done)
+
+	asgState.StatusReason = fmt.Sprintf("Draining instances %s.", strings.Join(asgState.DrainingInstances, ", "))
+	if len(blocked) > 0 {
+		asgState.StatusReason = fmt.Sprintf("Eviction of pods %s is blocked by PodDisruptionBudgets.", strings.Join(blocked, ", "))
+	}
+	return nil
+}
+
+// Cancel uncordons the nodes being drained and restores the launch template
+// version configured on the ASG before. The ASG keeps its surged capacity,
+// scaling it in would terminate instances without draining them, which is
+// reported instead. Without kubeconfig the nodes stay cordoned.
+func (r *surgeRoller) Cancel(ctx context.Context, asgState *ASGState) error {
+	err := r.uncordon(ctx, asgState)
+	if err != nil {
+		return err
+	}
+	asgState.DrainingSince = nil
+
+	asg, err := r.describe(asgState)
+	if err != nil {
+		return err
+	}
+	if asg == nil {
+		return nil
+	}
+	err = r.restore(asgState, asg)
+	if err != nil {
+		return err
+	}
+
+	var leftover []string
+	if surged := aws.Int64Value(asg.DesiredCapacity) - asgState.DesiredCapacity; asgState.DesiredCapacity > 0 && surged > 0 {
+		leftover = append(leftover, fmt.Sprintf("%d surged instances beyond its desired capacity of %d", surged, asgState.DesiredCapacity))
+	}
+	if asgState.MaxSize > 0 && aws.Int64Value(asg.MaxSize) > asgState.MaxSize {
+		leftover = append(leftover, fmt.Sprintf("its maximum size raised from %d to %d", asgState.MaxSize, aws.Int64Value(asg.MaxSize)))
+	}
+	if len(leftover) == 0 {
+		r.s.Scope.Logger.Info(fmt.Sprintf("Stopped replacing instances in ASG %s", asgState.Name))
+		return nil
+	}
+	asgState.StatusReason = fmt.Sprintf("Stopped replacing instances, ASG %s keeps %s. Scale it in by hand once its nodes are drained.",
+		asgState.Name, strings.Join(leftover, " and "))
+	r.s.Scope.Logger.Info(asgState.StatusReason)
+	r.s.event(v1.EventTypeWarning, "SurgeCapacityLeft", asgState.StatusReason)
+	return nil
+}
+
+// uncordon uncordons the nodes of the instances of the given ASG being
+// drained.
+func (r *surgeRoller) uncordon(ctx context.Context, asgState *ASGState) error {
+	if len(asgState.DrainingInstances) == 0 {
+		return nil
+	}
+	k8sClient, err := r.s.workloadCluster(ctx)
+	if IsTransient(err) {
+		return err
+	} else if err != nil {
+		r.s.Scope.Logger.Info(fmt.Sprintf("Skipping uncordoning the nodes of ASG %s: %s", asgState.Name, err))
+		return nil
+	}
+	nodes, err := workload.NodesByInstanceID(ctx, k8sClient)
+	if err != nil {
+		return microerror.Maskf(transientError, "failed to list nodes of ASG %s: %s", asgState.Name, err)
+	}
+	for _, id := range asgState.DrainingInstances {
+		if node, ok := nodes[id]; ok {
+			err = workload.Uncordon(ctx, k8sClient, node)
+			if err != nil {
+				return microerror.Maskf(transientError, "failed to uncordon node %s: %s", node.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Restore restores the launch template version configured on the ASG before
+// its roll started once the roll did not succeed. ASGs which got rolled back
+// keep the launch template version they got rolled back to.
+func (r *surgeRoller) Restore(ctx context.Context, asgState *ASGState) error {
+	if asgState.ConfiguredLaunchTemplateVersion == "" || asgState.Status == ASGStatusRolledBack {
+		return nil
+	}
+	asg, err := r.describe(asgState)
+	if err != nil {
+		return err
+	}
+	if asg == nil {
+		asgState.ConfiguredLaunchTemplateVersion = ""
+		return nil
+	}
+	return r.restore(asgState, asg)
+}
+
+// surge raises the desired capacity of the ASG for the next batch of outdated
+// instances, which get drained once the new instances are Ready. The maximum
+// size is raised along if needed. Outdated instances get terminated while
+// decrementing the desired capacity, so each batch surges from the desired
+// capacity recorded at the start. Nothing is raised if the ASG already
+// reached it, e.g. because the previous batch got surged but not recorded.
+func (r *surgeRoller) surge(asgState *ASGState, asg *autoscaling.Group) error {
+	batch := asgState.InstancesToReplace
+	if int64(len(batch)) > asgState.Surge {
+		batch = batch[:asgState.Surge]
+	}
+	desiredCapacity := asgState.DesiredCapacity + int64(len(batch))
+	if aws.Int64Value(asg.DesiredCapacity) < desiredCapacity {
+		input := &autoscaling.UpdateAutoScalingGroupInput{
+			AutoScalingGroupName: asg.AutoScalingGroupName,
+			DesiredCapacity:      aws.Int64(desiredCapacity),
+		}
+		if desiredCapacity > aws.Int64Value(asg.MaxSize) {
+			input.MaxSize = aws.Int64(desiredCapacity)
+		}
+		_, err := r.s.ASG.Client.UpdateAutoScalingGroup(input)
+		if err != nil {
+			r.s.Scope.Logger.Error(err, "failed to update autoscaling group")
+			return err
+		}
+		r.s.Scope.Logger.Info(fmt.Sprintf("Raised desired capacity of ASG %s to %d", asgState.Name, desiredCapacity))
+	}
+
+	asgState.DrainingInstances = append([]string{}, batch...)
+	asgState.StatusReason = fmt.Sprintf("Launching %d instances ahead of draining %s.", len(batch), strings.Join(batch, ", "))
+	return nil
+}
+
+// finish restores the maximum size of the ASG once all outdated instances got
+// replaced.
+func (r *surgeRoller) finish(asgState *ASGState, asg *autoscaling.Group) error {
+	if asgState.MaxSize > 0 && aws.Int64Value(asg.MaxSize) > asgState.MaxSize && aws.Int64Value(asg.DesiredCapacity) <= asgState.MaxSize {
+		_, err := r.s.ASG.Client.UpdateAutoScalingGroup(&autoscaling.UpdateAutoScalingGroupInput{
+			AutoScalingGroupName: asg.AutoScalingGroupName,
+			MaxSize:              aws.Int64(asgState.MaxSize),
+		})
+		if err != nil {
+			r.s.Scope.Logger.Error(err, "failed to update autoscaling group")
+			return err
+		}
+	}
+	asgState.Status = autoscaling.InstanceRefreshStatusSuccessful
+	asgState.StatusReason = ""
+	r.s.Scope.Logger.Info(fmt.Sprintf("Successfully replaced all instances in ASG %s", asgState.Name))
+	return nil
+}
+
+// restore configures the ASG to launch instances of the launch template
+// version it was configured with before the roll started.
+func (r *surgeRoller) restore(asgState *ASGState, asg *autoscaling.Group) error {
+	version := asgState.ConfiguredLaunchTemplateVersion
+	if version == "" || configuredLaunchTemplateVersion(asg) == version {
+		asgState.ConfiguredLaunchTemplateVersion = ""
+		return nil
+	}
+	desired, err := desiredConfiguration(asg, version)
+	if err != nil {
+		r.s.Scope.Logger.Info(fmt.Sprintf("Not restoring launch template version %s of ASG %s: %s", version, asgState.Name, err))
+		asgState.ConfiguredLaunchTemplateVersion = ""
+		return nil
+	}
+	_, err = r.s.ASG.Client.UpdateAutoScalingGroup(&autoscaling.UpdateAutoScalingGroupInput{
+		AutoScalingGroupName: asg.AutoScalingGroupName,
+		LaunchTemplate:       desired.LaunchTemplate,
+		MixedInstancesPolicy: desired.MixedInstancesPolicy,
+	})
+	if err != nil {
+		r.s.Scope.Logger.Error(err, "failed to update autoscaling group")
+		return err
+	}
+	asgState.ConfiguredLaunchTemplateVersion = ""
+	r.s.Scope.Logger.Info(fmt.Sprintf("Restored launch template version %s of ASG %s", version, asgState.Name))
+	return nil
+}
+
+func (r *surgeRoller) updateProgress(asgState *ASGState) {
+	asgState.InstancesRemaining = int64(len(asgState.InstancesToReplace))
+	if asgState.InstancesToUpdate > 0 {
+		asgState.PercentageComplete = (asgState.InstancesToUpdate - asgState.InstancesRemaining) * 100 / asgState.InstancesToUpdate
+	}
+}
+
+// describe returns the given ASG or nil if it does not exist anymore.
+func (r *surgeRoller) describe(asgState *ASGState) (*autoscaling.Group, error) {
+	output, err := r.s.ASG.Client.DescribeAutoScalingGroups(&autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: []*string{aws.String(asgState.Name)},
+	})
+	if err != nil {
+		r.s.Scope.Logger.Error(err, "failed to describe autoscaling group")
+		return nil, err
+	}
+	if len(output.AutoScalingGroups) == 0 {
+		return nil, nil
+	}
+	return output.AutoScalingGroups[0], nil
+}
+
+// filterInstances returns the given instance IDs which are in instances.
+func filterInstances(ids []string, instances map[string]*autoscaling.Instance) []string {
+	var result []string
+	for _, id := range ids {
+		if _, ok := instances[id]; ok {
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+// excludeInstances returns the given instance IDs which are not in instances.
+func excludeInstances(ids []string, instances map[string]*autoscaling.Instance) []string {
+	var result []string
+	for _, id := range ids {
+		if _, ok := instances[id]; !ok {
+			result = append(result, id)
+		}
+	}
+	return result
+}
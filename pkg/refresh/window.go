@@ -0,0 +1,63 @@
+package refresh
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/giantswarm/microerror"
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/giantswarm/aws-rolling-node-operator/pkg/window"
+)
+
+// Maintenance window policies decide what happens to instance refreshes in
+// progress when their maintenance window closes.
+const (
+	// MaintenanceWindowPolicyFinish finishes the rolls in flight and queues
+	// the remaining ASGs until the maintenance window opens again.
+	MaintenanceWindowPolicyFinish = "Finish"
+	// MaintenanceWindowPolicyCancel cancels the instance refresh.
+	MaintenanceWindowPolicyCancel = "Cancel"
+)
+
+// maintenanceWindow parses the maintenance window of the instance refresh,
+// which is nil if none is set.
+func maintenanceWindow(preferences Preferences) (*window.Window, error) {
+	switch preferences.MaintenanceWindowPolicy {
+	case "", MaintenanceWindowPolicyFinish, MaintenanceWindowPolicyCancel:
+	default:
+		return nil, fmt.Errorf("Maintenance window policy must be %s or %s, got %s",
+			MaintenanceWindowPolicyFinish, MaintenanceWindowPolicyCancel, preferences.MaintenanceWindowPolicy)
+	}
+	if preferences.MaintenanceWindow == "" {
+		return nil, nil
+	}
+	w, err := window.Parse(preferences.MaintenanceWindow)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	return w, nil
+}
+
+// queue holds back the ASGs which did not start yet until the maintenance
+// window opens again and tells the user when that is.
+func (s *InstanceRefreshService) queue(state *State, w *window.Window) {
+	if state.Phase == PhaseQueued {
+		return
+	}
+	state.Phase = PhaseQueued
+	message := fmt.Sprintf("Maintenance window %q is closed, queueing the instance refresh until it opens at %s.",
+		w, w.NextOpen(time.Now()).UTC().Format(time.RFC3339))
+	s.Scope.Logger.Info(message)
+	s.event(v1.EventTypeNormal, "InstanceRefreshQueued", message)
+}
+
+// closeWindow cancels all rolls in flight because the maintenance window
+// closed.
+func (s *InstanceRefreshService) closeWindow(ctx context.Context, state *State, w *window.Window) (bool, error) {
+	message := fmt.Sprintf("Maintenance window %q closed, cancelling the instance refresh.", w)
+	s.Scope.Logger.Info(message)
+	s.event(v1.EventTypeWarning, "MaintenanceWindowClosed", message)
+	return s.cancel(ctx, state)
+}
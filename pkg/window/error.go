@@ -0,0 +1,17 @@
+package window
+
+import (
+	"errors"
+
+	"github.com/giantswarm/microerror"
+)
+
+var invalidConfigError = &microerror.Error{
+	Kind: "invalidConfigError",
+}
+
+// IsInvalidConfig asserts invalidConfigError. It is returned for maintenance
+// windows which can not be parsed.
+func IsInvalidConfig(err error) bool {
+	return errors.Is(err, invalidConfigError)
+}
@@ -0,0 +1,170 @@
+// Package window parses maintenance windows, the weekly recurring times
+// instance refreshes may run in.
+package window
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/giantswarm/microerror"
+)
+
+const minutesPerDay = 24 * 60
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// Window is a maintenance window made of weekly recurring time ranges.
+type Window struct {
+	spec   string
+	ranges []timeRange
+}
+
+// timeRange opens on the given weekdays at start and closes at end, both in
+// minutes after midnight in its location. Ranges closing before they open
+// close on the next day.
+type timeRange struct {
+	days     [7]bool
+	start    int
+	end      int
+	location *time.Location
+}
+
+// Parse parses a maintenance window made of time ranges separated by
+// semicolons, e.g. "Mon-Fri 22:00-05:00 Europe/Berlin; Sat,Sun 00:00-24:00".
+// A time range consists of the weekdays it opens on, as comma separated days
+// or ranges of days or * for every day, the times it opens and closes and an
+// optional time zone, which defaults to UTC.
+func Parse(spec string) (*Window, error) {
+	w := &Window{spec: strings.TrimSpace(spec)}
+	for _, s := range strings.Split(w.spec, ";") {
+		r, err := parseRange(strings.Fields(s))
+		if err != nil {
+			return nil, microerror.Maskf(invalidConfigError, "Maintenance window %q is invalid: %s", spec, err)
+		}
+		w.ranges = append(w.ranges, r)
+	}
+	return w, nil
+}
+
+func parseRange(fields []string) (timeRange, error) {
+	r := timeRange{location: time.UTC}
+	if len(fields) < 2 || len(fields) > 3 {
+		return r, fmt.Errorf("expected weekdays, times and an optional time zone, got %q", strings.Join(fields, " "))
+	}
+
+	for _, d := range strings.Split(fields[0], ",") {
+		if d == "*" {
+			r.days = [7]bool{true, true, true, true, true, true, true}
+			continue
+		}
+		days := strings.SplitN(d, "-", 2)
+		first, ok := weekdays[strings.ToLower(days[0])]
+		if !ok {
+			return r, fmt.Errorf("unknown weekday %q", days[0])
+		}
+		last := first
+		if len(days) == 2 {
+			last, ok = weekdays[strings.ToLower(days[1])]
+			if !ok {
+				return r, fmt.Errorf("unknown weekday %q", days[1])
+			}
+		}
+		for day := first; ; day = (day + 1) % 7 {
+			r.days[day] = true
+			if day == last {
+				break
+			}
+		}
+	}
+
+	times := strings.SplitN(fields[1], "-", 2)
+	if len(times) != 2 {
+		return r, fmt.Errorf("expected times like 22:00-05:00, got %q", fields[1])
+	}
+	var err error
+	r.start, err = parseTime(times[0])
+	if err != nil {
+		return r, err
+	}
+	r.end, err = parseTime(times[1])
+	if err != nil {
+		return r, err
+	}
+	if r.start == minutesPerDay || r.start == r.end {
+		return r, fmt.Errorf("times %q do not describe a time range", fields[1])
+	}
+
+	if len(fields) == 3 {
+		r.location, err = time.LoadLocation(fields[2])
+		if err != nil {
+			return r, fmt.Errorf("unknown time zone %q", fields[2])
+		}
+	}
+	return r, nil
+}
+
+// parseTime returns the minutes after midnight of a time of day like 22:00.
+// 24:00 is the end of the day.
+func parseTime(value string) (int, error) {
+	if value == "24:00" {
+		return minutesPerDay, nil
+	}
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("expected a time like 22:00, got %q", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// String returns the maintenance window as it got parsed.
+func (w *Window) String() string {
+	return w.spec
+}
+
+// Open returns true if the maintenance window is open at the given time.
+func (w *Window) Open(t time.Time) bool {
+	for _, r := range w.ranges {
+		if r.open(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// NextOpen returns the time the maintenance window opens next, or the given
+// time if it is open.
+func (w *Window) NextOpen(t time.Time) time.Time {
+	if w.Open(t) {
+		return t
+	}
+	// Every window opens at least once a week on a full minute. Walking
+	// minute by minute keeps daylight saving time changes out of the way.
+	next := t.Truncate(time.Minute)
+	for i := 0; i <= 8*minutesPerDay; i++ {
+		next = next.Add(time.Minute)
+		if w.Open(next) {
+			return next
+		}
+	}
+	return time.Time{}
+}
+
+func (r timeRange) open(t time.Time) bool {
+	t = t.In(r.location)
+	minute := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+	if r.start < r.end {
+		return r.days[day] && minute >= r.start && minute < r.end
+	}
+	previous := (day + 6) % 7
+	return (r.days[day] && minute >= r.start) || (r.days[previous] && minute < r.end)
+}
@@ -0,0 +1,83 @@
+package window
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		name        string
+		spec        string
+		expectError bool
+	}{
+		{name: "weekdays", spec: "Mon-Fri 22:00-05:00 Europe/Berlin"},
+		{name: "multiple ranges", spec: "Mon-Fri 22:00-05:00; Sat,Sun 00:00-24:00"},
+		{name: "every day", spec: "* 01:00-03:00"},
+		{name: "unknown weekday", spec: "Mon-Fry 22:00-05:00", expectError: true},
+		{name: "no times", spec: "Mon-Fri", expectError: true},
+		{name: "invalid time", spec: "Mon-Fri 22:00-25:00", expectError: true},
+		{name: "empty range", spec: "Mon-Fri 22:00-22:00", expectError: true},
+		{name: "unknown time zone", spec: "Mon-Fri 22:00-05:00 Europe/Nowhere", expectError: true},
+		{name: "empty", spec: "", expectError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Parse(tc.spec)
+			if tc.expectError {
+				if !IsInvalidConfig(err) {
+					t.Fatalf("expected invalid config error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestOpen(t *testing.T) {
+	w, err := Parse("Mon-Fri 22:00-05:00 Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name     string
+		time     time.Time
+		expected bool
+	}{
+		{name: "monday evening", time: time.Date(2022, 3, 7, 21, 30, 0, 0, time.UTC), expected: true},
+		{name: "monday before the window", time: time.Date(2022, 3, 7, 20, 0, 0, 0, time.UTC), expected: false},
+		{name: "monday morning", time: time.Date(2022, 3, 7, 3, 0, 0, 0, time.UTC), expected: false},
+		{name: "saturday morning", time: time.Date(2022, 3, 12, 3, 0, 0, 0, time.UTC), expected: true},
+		{name: "sunday morning", time: time.Date(2022, 3, 13, 3, 0, 0, 0, time.UTC), expected: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if open := w.Open(tc.time); open != tc.expected {
+				t.Fatalf("expected open %t, got %t", tc.expected, open)
+			}
+		})
+	}
+}
+
+func TestNextOpen(t *testing.T) {
+	w, err := Parse("Mon-Fri 22:00-05:00 Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Sunday, the window opens on Monday at 22:00 in Berlin.
+	next := w.NextOpen(time.Date(2022, 3, 13, 3, 0, 0, 0, time.UTC))
+	if expected := time.Date(2022, 3, 14, 21, 0, 0, 0, time.UTC); !next.Equal(expected) {
+		t.Fatalf("expected %s, got %s", expected, next)
+	}
+	now := time.Date(2022, 3, 7, 21, 30, 0, 0, time.UTC)
+	if next := w.NextOpen(now); !next.Equal(now) {
+		t.Fatalf("expected %s, got %s", now, next)
+	}
+}
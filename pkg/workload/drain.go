@@ -0,0 +1,101 @@
+package workload
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/giantswarm/microerror"
+	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// mirrorPodAnnotation is set on the API server's copies of static pods,
+// which can not be evicted.
+const mirrorPodAnnotation = "kubernetes.io/config.mirror"
+
+// DrainStatus describes the pods left on a node being drained.
+type DrainStatus struct {
+	// Remaining is the number of pods which still have to leave the node.
+	Remaining int
+	// Blocked are the pods whose eviction got refused because it would
+	// violate a PodDisruptionBudget.
+	Blocked []string
+}
+
+// Cordon marks the given node unschedulable.
+func Cordon(ctx context.Context, k8sClient kubernetes.Interface, node *v1.Node) error {
+	return setUnschedulable(ctx, k8sClient, node, true)
+}
+
+// Uncordon marks the given node schedulable again.
+func Uncordon(ctx context.Context, k8sClient kubernetes.Interface, node *v1.Node) error {
+	return setUnschedulable(ctx, k8sClient, node, false)
+}
+
+func setUnschedulable(ctx context.Context, k8sClient kubernetes.Interface, node *v1.Node, unschedulable bool) error {
+	if node.Spec.Unschedulable == unschedulable {
+		return nil
+	}
+	patch := []byte(fmt.Sprintf(`{"spec":{"unschedulable":%t}}`, unschedulable))
+	_, err := k8sClient.CoreV1().Nodes().Patch(ctx, node.Name, types.StrategicMergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	return nil
+}
+
+// Drain evicts the pods of the given node through the eviction API, so
+// PodDisruptionBudgets are respected. It does not wait for the pods to leave,
+// callers are expected to call it again until no pods remain. DaemonSet pods,
+// static pods and finished pods are left alone.
+func Drain(ctx context.Context, k8sClient kubernetes.Interface, nodeName string) (*DrainStatus, error) {
+	pods, err := k8sClient.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{
+		FieldSelector: fmt.Sprintf("spec.nodeName=%s", nodeName),
+	})
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	status := &DrainStatus{}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Spec.NodeName != nodeName || !evictable(pod) {
+			continue
+		}
+		status.Remaining++
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+
+		err = k8sClient.CoreV1().Pods(pod.Namespace).EvictV1(ctx, &policyv1.Eviction{
+			ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace},
+		})
+		if errors.IsTooManyRequests(err) {
+			status.Blocked = append(status.Blocked, fmt.Sprintf("%s/%s", pod.Namespace, pod.Name))
+		} else if errors.IsNotFound(err) {
+			status.Remaining--
+		} else if err != nil {
+			return nil, microerror.Mask(err)
+		}
+	}
+	return status, nil
+}
+
+func evictable(pod *v1.Pod) bool {
+	if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+		return false
+	}
+	if _, ok := pod.Annotations[mirrorPodAnnotation]; ok {
+		return false
+	}
+	for _, owner := range pod.OwnerReferences {
+		if owner.Kind == "DaemonSet" && owner.Controller != nil && *owner.Controller {
+			return false
+		}
+	}
+	return true
+}
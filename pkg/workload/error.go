@@ -0,0 +1,17 @@
+package workload
+
+import (
+	"errors"
+
+	"github.com/giantswarm/microerror"
+)
+
+var invalidConfigError = &microerror.Error{
+	Kind: "invalidConfigError",
+}
+
+// IsInvalidConfig asserts invalidConfigError. It is returned for clusters
+// whose kubeconfig secret can not be used.
+func IsInvalidConfig(err error) bool {
+	return errors.Is(err, invalidConfigError)
+}
@@ -0,0 +1,31 @@
+package workload
+
+import (
+	"context"
+	"sort"
+
+	"github.com/giantswarm/microerror"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// DaemonSetsNotRolledOut returns the names of the DaemonSets in the given
+// namespace whose pods are not all updated and available yet, like kubectl
+// rollout status reports them.
+func DaemonSetsNotRolledOut(ctx context.Context, k8sClient kubernetes.Interface, namespace string) ([]string, error) {
+	daemonSets, err := k8sClient.AppsV1().DaemonSets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	var result []string
+	for _, ds := range daemonSets.Items {
+		if ds.Status.ObservedGeneration < ds.Generation ||
+			ds.Status.UpdatedNumberScheduled < ds.Status.DesiredNumberScheduled ||
+			ds.Status.NumberAvailable < ds.Status.DesiredNumberScheduled {
+			result = append(result, ds.Name)
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
@@ -0,0 +1,69 @@
+package workload
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/giantswarm/microerror"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// BlockingPodDisruptionBudgets returns the PodDisruptionBudgets which
+// currently allow no disruptions and select pods on the given nodes, as
+// namespace/name. Evicting these pods would be refused, so draining the nodes
+// would not make progress. Pods which are not evicted when draining are
+// ignored.
+func BlockingPodDisruptionBudgets(ctx context.Context, k8sClient kubernetes.Interface, nodeNames []string) ([]string, error) {
+	pdbs, err := k8sClient.PolicyV1().PodDisruptionBudgets(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	var exhausted []*policyv1.PodDisruptionBudget
+	for i := range pdbs.Items {
+		if pdbs.Items[i].Status.DisruptionsAllowed == 0 {
+			exhausted = append(exhausted, &pdbs.Items[i])
+		}
+	}
+	if len(exhausted) == 0 {
+		return nil, nil
+	}
+
+	blocking := map[string]bool{}
+	for _, nodeName := range nodeNames {
+		pods, err := k8sClient.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{
+			FieldSelector: fmt.Sprintf("spec.nodeName=%s", nodeName),
+		})
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+		for i := range pods.Items {
+			pod := &pods.Items[i]
+			if pod.Spec.NodeName != nodeName || !evictable(pod) {
+				continue
+			}
+			for _, pdb := range exhausted {
+				if pdb.Namespace != pod.Namespace {
+					continue
+				}
+				selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+				if err != nil {
+					continue
+				}
+				if selector.Matches(labels.Set(pod.Labels)) {
+					blocking[fmt.Sprintf("%s/%s", pdb.Namespace, pdb.Name)] = true
+				}
+			}
+		}
+	}
+
+	var result []string
+	for name := range blocking {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result, nil
+}
@@ -0,0 +1,95 @@
+// Package workload connects to workload clusters through the kubeconfig
+// secrets in the management cluster and manages their nodes.
+package workload
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/giantswarm/microerror"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// kubeconfigKeys are the keys of the kubeconfig secret holding the
+// kubeconfig. Cluster API uses value, Giant Swarm clusters kubeConfig.
+var kubeconfigKeys = []string{"value", "kubeConfig"}
+
+// KubeconfigSecretName returns the name of the secret holding the kubeconfig
+// of the given workload cluster.
+func KubeconfigSecretName(clusterName string) string {
+	return fmt.Sprintf("%s-kubeconfig", clusterName)
+}
+
+// NewClient returns a client of the given workload cluster, built from its
+// kubeconfig secret in the given namespace.
+func NewClient(ctx context.Context, c client.Client, namespace, clusterName string) (kubernetes.Interface, error) {
+	secret := &v1.Secret{}
+	err := c.Get(ctx, types.NamespacedName{Name: KubeconfigSecretName(clusterName), Namespace: namespace}, secret)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	var kubeconfig []byte
+	for _, k := range kubeconfigKeys {
+		if v, ok := secret.Data[k]; ok {
+			kubeconfig = v
+			break
+		}
+	}
+	if kubeconfig == nil {
+		return nil, microerror.Maskf(invalidConfigError, "Secret %s/%s holds no kubeconfig", namespace, secret.Name)
+	}
+
+	config, err := clientcmd.RESTConfigFromKubeConfig(kubeconfig)
+	if err != nil {
+		return nil, microerror.Maskf(invalidConfigError, "Kubeconfig of cluster %s is invalid: %s", clusterName, err)
+	}
+	k8sClient, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	return k8sClient, nil
+}
+
+// InstanceID returns the ID of the EC2 instance backing the given node, taken
+// from its provider ID, e.g. aws:///eu-west-1a/i-0123456789abcdef0.
+func InstanceID(node *v1.Node) string {
+	providerID := node.Spec.ProviderID
+	if !strings.HasPrefix(providerID, "aws://") {
+		return ""
+	}
+	return providerID[strings.LastIndex(providerID, "/")+1:]
+}
+
+// NodesByInstanceID returns the nodes of the workload cluster by the ID of the
+// EC2 instance backing them.
+func NodesByInstanceID(ctx context.Context, k8sClient kubernetes.Interface) (map[string]*v1.Node, error) {
+	nodes, err := k8sClient.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	result := map[string]*v1.Node{}
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		if id := InstanceID(node); id != "" {
+			result[id] = node
+		}
+	}
+	return result, nil
+}
+
+// Ready returns true if the given node reports the Ready condition.
+func Ready(node *v1.Node) bool {
+	for _, c := range node.Status.Conditions {
+		if c.Type == v1.NodeReady {
+			return c.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}